@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Assertion checks a substituted value once a request has completed, e.g.
+// `- value: "{{create_user.id}}", not_equals: ""`. A `plugin` assertion is
+// delegated to the plugin registering that name.
+type Assertion struct {
+	Name      string                 `yaml:"name"`
+	Value     string                 `yaml:"value"`
+	Equals    *string                `yaml:"equals"`
+	NotEquals *string                `yaml:"not_equals"`
+	Contains  *string                `yaml:"contains"`
+	Matches   string                 `yaml:"matches"`
+	LT        *float64               `yaml:"lt"`
+	LTE       *float64               `yaml:"lte"`
+	GT        *float64               `yaml:"gt"`
+	GTE       *float64               `yaml:"gte"`
+	Plugin    string                 `yaml:"plugin"`
+	Args      map[string]interface{} `yaml:"args"`
+}
+
+// label describes the assertion in the output.
+func (a Assertion) label() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	if a.Plugin != "" {
+		return fmt.Sprintf("%s(%s)", a.Plugin, a.Value)
+	}
+	return a.Value
+}
+
+// check compares a resolved value against the assertion's matchers. It
+// returns a failure message, or an empty string when all matchers pass.
+func (a Assertion) check(actual string) (string, error) {
+	if a.Equals != nil && actual != *a.Equals {
+		return fmt.Sprintf("expected %q to equal %q", actual, *a.Equals), nil
+	}
+	if a.NotEquals != nil && actual == *a.NotEquals {
+		return fmt.Sprintf("expected %q to not equal %q", actual, *a.NotEquals), nil
+	}
+	if a.Contains != nil && !strings.Contains(actual, *a.Contains) {
+		return fmt.Sprintf("expected %q to contain %q", actual, *a.Contains), nil
+	}
+	if a.Matches != "" {
+		re, err := regexp.Compile(a.Matches)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern %q: %w", a.Matches, err)
+		}
+		if !re.MatchString(actual) {
+			return fmt.Sprintf("expected %q to match %q", actual, a.Matches), nil
+		}
+	}
+
+	bounds := []struct {
+		limit *float64
+		op    string
+		ok    func(v, limit float64) bool
+	}{
+		{a.LT, "<", func(v, limit float64) bool { return v < limit }},
+		{a.LTE, "<=", func(v, limit float64) bool { return v <= limit }},
+		{a.GT, ">", func(v, limit float64) bool { return v > limit }},
+		{a.GTE, ">=", func(v, limit float64) bool { return v >= limit }},
+	}
+	for _, b := range bounds {
+		if b.limit == nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(actual, 64)
+		if err != nil {
+			return fmt.Sprintf("expected %q to be a number", actual), nil
+		}
+		if !b.ok(v, *b.limit) {
+			return fmt.Sprintf("expected %v %s %v", v, b.op, *b.limit), nil
+		}
+	}
+
+	return "", nil
+}
+
+// runAssertions evaluates all assertions of a request, prints their outcome
+// and fails if any of them did not pass.
+func (r *Runner) runAssertions(assertions []Assertion, resp *scriptResponse) error {
+	if len(assertions) == 0 {
+		return nil
+	}
+
+	fmt.Printf("\n%sAssertions:%s\n", colorBold, colorReset)
+	failed := 0
+	for _, a := range assertions {
+		actual := r.substitute(a.Value)
+
+		var message string
+		var err error
+		if a.Plugin != "" {
+			var ok bool
+			ok, message, err = r.pluginAssert(a.Plugin, actual, a.Args, resp)
+			if err == nil && ok {
+				message = ""
+			} else if err == nil && message == "" {
+				message = "assertion failed"
+			}
+		} else {
+			message, err = a.check(actual)
+		}
+		if err != nil {
+			return fmt.Errorf("%sassertion %q: %v%s", colorRed, a.label(), err, colorReset)
+		}
+
+		if message == "" {
+			fmt.Printf("  %sPASS%s %s\n", colorGreen, colorReset, a.label())
+		} else {
+			failed++
+			fmt.Printf("  %sFAIL%s %s: %s\n", colorRed, colorReset, a.label(), message)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%s%d of %d assertions failed%s", colorRed, failed, len(assertions), colorReset)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// stringList is a repeatable command-line flag collecting its values, such
+// as the paths given with -file.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// configLoader merges configuration files, following their includes and
+// remembering where every environment, request and group was defined.
+type configLoader struct {
+	config  Config
+	visited map[string]bool
+	origins map[string]string
+}
+
+// loadConfig reads and merges the given files and directories. Directories
+// contribute all of their *.yaml and *.yml files in lexical order.
+func loadConfig(paths []string) (Config, error) {
+	l := &configLoader{
+		config: Config{
+			Environments: yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+			Requests:     yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+			Groups:       make(map[string]Group),
+			Generators:   make(map[string]GeneratorDef),
+			requestDirs:  make(map[string]string),
+			envDirs:      make(map[string]string),
+		},
+		visited: make(map[string]bool),
+		origins: make(map[string]string),
+	}
+
+	for _, path := range paths {
+		files, err := expandConfigPath(path)
+		if err != nil {
+			return Config{}, err
+		}
+		for _, file := range files {
+			if err := l.loadFile(file); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+
+	if len(l.config.Environments.Content) == 0 {
+		return Config{}, fmt.Errorf("%sno environments defined%s", colorRed, colorReset)
+	}
+
+	return l.config, nil
+}
+
+// expandConfigPath returns the YAML files a path refers to.
+func expandConfigPath(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%sfailed to read file: %w%s", colorRed, err, colorReset)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%sfailed to list directory %q: %w%s", colorRed, path, err, colorReset)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (l *configLoader) loadFile(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%sfailed to resolve path %q: %w%s", colorRed, path, err, colorReset)
+	}
+	if l.visited[absPath] {
+		return nil
+	}
+	l.visited[absPath] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%sfailed to read file: %w%s", colorRed, err, colorReset)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%sfailed to parse YAML %s: %w%s", colorRed, path, err, colorReset)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	var cfg Config
+	if err := doc.Decode(&cfg); err != nil {
+		return fmt.Errorf("%sfailed to parse YAML %s: %w%s", colorRed, path, err, colorReset)
+	}
+
+	if err := l.mergeMapping(&l.config.Environments, &cfg.Environments, "environment", path); err != nil {
+		return err
+	}
+	if err := l.mergeMapping(&l.config.Requests, &cfg.Requests, "request", path); err != nil {
+		return err
+	}
+	for i := 0; i < len(cfg.Requests.Content); i += 2 {
+		l.config.requestDirs[cfg.Requests.Content[i].Value] = filepath.Dir(absPath)
+	}
+	for i := 0; i < len(cfg.Environments.Content); i += 2 {
+		l.config.envDirs[cfg.Environments.Content[i].Value] = filepath.Dir(absPath)
+	}
+
+	groupsNode := mappingValue(doc.Content[0], "groups")
+	for name, group := range cfg.Groups {
+		line := 0
+		if groupsNode != nil {
+			if keyNode := mappingKey(groupsNode, name); keyNode != nil {
+				line = keyNode.Line
+			}
+		}
+		if err := l.claim("group", name, path, line); err != nil {
+			return err
+		}
+		l.config.Groups[name] = group
+	}
+
+	dir := filepath.Dir(path)
+	generatorsNode := mappingValue(doc.Content[0], "generators")
+	for name, def := range cfg.Generators {
+		line := 0
+		if keyNode := mappingKey(generatorsNode, name); keyNode != nil {
+			line = keyNode.Line
+		}
+		if err := l.claim("generator", name, path, line); err != nil {
+			return err
+		}
+		if err := def.loadValues(dir); err != nil {
+			return fmt.Errorf("%s%s:%d: generator %q: %v%s", colorRed, path, line, name, err, colorReset)
+		}
+		l.config.Generators[name] = def
+	}
+
+	for _, pattern := range cfg.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%sinvalid include pattern %q in %s: %w%s", colorRed, pattern, path, err, colorReset)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("%sinclude %q in %s not found%s", colorRed, pattern, path, colorReset)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			files, err := expandConfigPath(match)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				if err := l.loadFile(file); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// mergeMapping appends the entries of src to dst, rejecting duplicate names.
+func (l *configLoader) mergeMapping(dst, src *yaml.Node, kind, path string) error {
+	if src.Kind == 0 {
+		return nil
+	}
+	if src.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s%s:%d: %ss must be a mapping%s", colorRed, path, src.Line, kind, colorReset)
+	}
+	for i := 0; i < len(src.Content); i += 2 {
+		keyNode := src.Content[i]
+		if err := l.claim(kind, keyNode.Value, path, keyNode.Line); err != nil {
+			return err
+		}
+		dst.Content = append(dst.Content, keyNode, src.Content[i+1])
+	}
+	return nil
+}
+
+// claim records where a name was defined and reports duplicates.
+func (l *configLoader) claim(kind, name, path string, line int) error {
+	key := kind + "/" + name
+	origin := fmt.Sprintf("%s:%d", path, line)
+	if first, ok := l.origins[key]; ok {
+		return fmt.Errorf("%sduplicate %s %q at %s (first defined at %s)%s", colorRed, kind, name, origin, first, colorReset)
+	}
+	l.origins[key] = origin
+	return nil
+}
+
+// mappingKey returns the key node with the given name in a mapping node.
+func mappingKey(node *yaml.Node, name string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value node with the given key in a mapping node.
+func mappingValue(node *yaml.Node, name string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files under dir from a map of relative paths to contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// requestNames lists the requests of a config in order.
+func requestNames(c Config) []string {
+	var names []string
+	for i := 0; i < len(c.Requests.Content); i += 2 {
+		names = append(names, c.Requests.Content[i].Value)
+	}
+	return names
+}
+
+func TestLoadConfigIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.yaml": `
+include: [common.yaml, "users/*.yaml"]
+environments:
+  local: {host: "http://localhost"}
+requests:
+  health: {url: "{{host}}/health"}
+`,
+		"common.yaml": `
+include: [main.yaml]
+groups:
+  smoke: [health]
+`,
+		"users/create.yaml": "requests:\n  create_user: {method: POST}\n",
+		"users/delete.yaml": "requests:\n  delete_user: {method: DELETE}\n",
+	})
+
+	config, err := loadConfig([]string{filepath.Join(dir, "main.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(requestNames(config), ","), "health,create_user,delete_user"; got != want {
+		t.Errorf("requests = %s, want %s", got, want)
+	}
+	if _, ok := config.Groups["smoke"]; !ok {
+		t.Error("group from the included file is missing")
+	}
+	if got := config.requestDirs["create_user"]; got != filepath.Join(dir, "users") {
+		t.Errorf("create_user directory = %s, want the users directory", got)
+	}
+}
+
+func TestLoadConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"b.yaml":     "requests:\n  second: {}\n",
+		"a.yml":      "environments:\n  local: {}\nrequests:\n  first: {}\n",
+		"notes.txt":  "requests: [not yaml config]\n",
+		"extra.yaml": "requests:\n  third: {}\n",
+	})
+	config, err := loadConfig([]string{dir, filepath.Join(dir, "b.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(requestNames(config), ","), "first,second,third"; got != want {
+		t.Errorf("requests = %s, want %s in lexical file order", got, want)
+	}
+}
+
+func TestLoadConfigDuplicates(t *testing.T) {
+	tests := []struct {
+		kind, first, second string
+	}{
+		{"request", "requests:\n  login: {}\n", "requests:\n  login: {}\n"},
+		{"environment", "environments:\n  local: {}\n", "environments:\n  local: {}\n"},
+		{"group", "groups:\n  smoke: []\n", "groups:\n  smoke: []\n"},
+		{"generator", "generators:\n  plan: [a]\n", "generators:\n  plan: [b]\n"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"a.yaml": "\n" + tt.first,
+			"b.yaml": "\n\n" + tt.second,
+			"c.yaml": "environments:\n  base: {}\n",
+		})
+		_, err := loadConfig([]string{dir})
+		a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+		want := fmt.Sprintf("at %s:4 (first defined at %s:3)", b, a)
+		if err == nil || !strings.Contains(err.Error(), "duplicate "+tt.kind) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error = %v, want a duplicate reported %s", tt.kind, err, want)
+		}
+	}
+}
+
+func TestLoadConfigMissingInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.yaml": "include: [missing.yaml, \"optional/*.yaml\"]\nenvironments:\n  local: {}\n",
+	})
+	_, err := loadConfig([]string{filepath.Join(dir, "main.yaml")})
+	if err == nil || !strings.Contains(err.Error(), "missing.yaml") {
+		t.Errorf("error = %v, want the missing include reported", err)
+	}
+}
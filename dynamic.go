@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeValue is a time produced by a dynamic variable. It is rendered with
+// layout unless a `format` filter chooses another one.
+type timeValue struct {
+	t      time.Time
+	layout string
+}
+
+// resolveDynamic resolves the reserved `$` variables. Unknown names are
+// reported as not found so they can still be looked up in the state.
+func (r *Runner) resolveDynamic(key string) (interface{}, bool) {
+	switch key {
+	case "$now":
+		return timeValue{time.Now(), time.RFC3339}, true
+	case "$now_unix":
+		return timeValue{time.Now(), "unix"}, true
+	case "$uuid":
+		return newUUID(4), true
+	case "$uuidv7":
+		return newUUID(7), true
+	case "$iteration":
+		return strconv.Itoa(r.iteration), true
+	case "$run_id":
+		return r.RunID, true
+	}
+
+	if name, ok := strings.CutPrefix(key, "$counter:"); ok {
+		return strconv.FormatInt(r.nextCounter(strings.TrimSpace(name)), 10), true
+	}
+	return nil, false
+}
+
+// nextCounter increments a named counter and persists it in the state under
+// `$counters`, so values keep increasing across runs.
+func (r *Runner) nextCounter(name string) int64 {
+	counters, ok := r.State["$counters"].(map[string]interface{})
+	if !ok {
+		counters = make(map[string]interface{})
+		r.State["$counters"] = counters
+	}
+
+	var n int64
+	switch v := counters[name].(type) {
+	case float64:
+		n = int64(v)
+	case int64:
+		n = v
+	}
+	n++
+	counters[name] = n
+	r.saveState()
+	return n
+}
+
+// newUUID returns a random version 4 UUID, or a time-ordered version 7 UUID.
+func newUUID(version int) string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	if version == 7 {
+		var ms [8]byte
+		binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+		copy(b[0:6], ms[2:8])
+	}
+	b[6] = byte(version)<<4 | b[6]&0x0f
+	b[8] = 0x80 | b[8]&0x3f
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// splitFilters separates `name | filter: arg | filter` into the variable
+// name and its filters.
+func splitFilters(key string) (string, []string) {
+	parts := strings.Split(key, "|")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts[0], parts[1:]
+}
+
+// applyFilters runs the filters over a resolved value and renders the result.
+//
+//	add: 1h      shifts a time by a duration (d and w units are allowed)
+//	format: ...  formats a time with a layout name, Go layout, unix or unix_ms
+//
+// Plain strings are parsed as times (RFC 3339, date-time or date) when a time
+// filter is applied to them.
+func applyFilters(val interface{}, filters []string) (string, error) {
+	for _, filter := range filters {
+		name, arg, _ := strings.Cut(filter, ":")
+		name = strings.TrimSpace(name)
+		arg = strings.Trim(strings.TrimSpace(arg), `"'`)
+
+		switch name {
+		case "add":
+			tv, err := asTime(val)
+			if err != nil {
+				return "", err
+			}
+			d, err := parseDuration(arg)
+			if err != nil {
+				return "", err
+			}
+			tv.t = tv.t.Add(d)
+			val = tv
+		case "format":
+			tv, err := asTime(val)
+			if err != nil {
+				return "", err
+			}
+			if arg == "" {
+				return "", fmt.Errorf("format filter needs a layout")
+			}
+			tv.layout = timeLayout(arg)
+			val = tv
+		default:
+			return "", fmt.Errorf("unknown filter %q", name)
+		}
+	}
+	return renderValue(val), nil
+}
+
+// asTime converts a value into a time for the time filters.
+func asTime(val interface{}) (timeValue, error) {
+	switch v := val.(type) {
+	case timeValue:
+		return v, nil
+	case string:
+		t, err := parseTimeArg(v, time.Now())
+		if err != nil {
+			return timeValue{}, err
+		}
+		return timeValue{t, time.RFC3339}, nil
+	}
+	return timeValue{}, fmt.Errorf("%v is not a time", val)
+}
+
+// renderValue turns a resolved variable into its substituted text.
+func renderValue(val interface{}) string {
+	if tv, ok := val.(timeValue); ok {
+		return formatTime(tv.t, tv.layout)
+	}
+	return fmt.Sprintf("%v", val)
+}
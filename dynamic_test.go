@@ -0,0 +1,84 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestApplyFilters(t *testing.T) {
+	at := timeValue{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), time.RFC3339}
+	tests := []struct {
+		val     interface{}
+		filters []string
+		want    string
+		wantErr bool
+	}{
+		{at, nil, "2024-01-02T03:04:05Z", false},
+		{at, []string{"add: 1h"}, "2024-01-02T04:04:05Z", false},
+		{at, []string{"add: -1d", `format: "2006-01-02"`}, "2024-01-01", false},
+		{at, []string{"add: 1w2d", "format: DateOnly"}, "2024-01-11", false},
+		{at, []string{"format: unix"}, "1704164645", false},
+		{at, []string{"format: unix_ms"}, "1704164645000", false},
+		{"2024-01-02", []string{"add: 12h", "format: DateTime"}, "2024-01-02 12:00:00", false},
+		{"plain", nil, "plain", false},
+		{"plain", []string{"add: 1h"}, "", true},
+		{at, []string{"format"}, "", true},
+		{at, []string{"add: soon"}, "", true},
+		{at, []string{"upper"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := applyFilters(tt.val, tt.filters)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("applyFilters(%v, %q) = %q, %v, want %q (error %v)", tt.val, tt.filters, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-([47])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, version := range []int{4, 7} {
+		id := newUUID(version)
+		m := pattern.FindStringSubmatch(id)
+		if m == nil || m[1] != string(rune('0'+version)) {
+			t.Errorf("newUUID(%d) = %s, not a version %d UUID", version, id, version)
+		}
+	}
+
+	first := newUUID(7)
+	time.Sleep(2 * time.Millisecond)
+	if second := newUUID(7); second[:13] <= first[:13] {
+		t.Errorf("version 7 UUIDs %s and %s are not time-ordered", first, second)
+	}
+}
+
+func TestCounterPersists(t *testing.T) {
+	r := newTestRunner(t)
+	if got := r.substitute("{{$counter:orders}} {{$counter:orders}} {{$counter: refunds}}"); got != "1 2 1" {
+		t.Errorf("counters = %q, want 1 2 1", got)
+	}
+
+	// A later run continues from the saved state
+	r.State = loadState(r.EnvName, r.StateFile)
+	if got := r.substitute("ORD-{{$counter:orders}}"); got != "ORD-3" {
+		t.Errorf("counter after reload = %q, want ORD-3", got)
+	}
+}
+
+func TestDynamicVariables(t *testing.T) {
+	r := newTestRunner(t)
+	r.RunID = "run-1"
+	r.iteration = 2
+	if got := r.substitute("{{$run_id}}/{{$iteration}}"); got != "run-1/2" {
+		t.Errorf("substitute = %q, want run-1/2", got)
+	}
+
+	before := time.Now().Unix()
+	if now, err := time.Parse(time.RFC3339, r.substitute("{{$now}}")); err != nil || now.Unix() < before {
+		t.Errorf("{{$now}} = %v, %v", now, err)
+	}
+	if unix, err := strconv.ParseInt(r.substitute("{{$now_unix}}"), 10, 64); err != nil || unix < before {
+		t.Errorf("{{$now_unix}} = %d, %v", unix, err)
+	}
+}
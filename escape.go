@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// substituteURL substitutes a request URL. `{name}` templates are filled from
+// pathParams first, then values are escaped for the part of the URL they land
+// in: the path and fragment are path-escaped, keeping `/` so that a value may
+// hold several segments, and the query is query-escaped. Values before the
+// path, such as `{{host}}`, are inserted as is.
+func (r *Runner) substituteURL(rawURL string, pathParams map[string]string) string {
+	if len(pathParams) > 0 {
+		rawURL = r.expandPathParams(rawURL, pathParams)
+	}
+	return r.substituteEscaped(rawURL, urlEscaper)
+}
+
+// urlEscaper escapes value for the URL component that follows prefix.
+func urlEscaper(prefix, value string) string {
+	switch {
+	case strings.Contains(prefix, "#"):
+		return pathEscape(value)
+	case strings.Contains(prefix, "?"):
+		return url.QueryEscape(value)
+	case inURLPath(prefix):
+		return pathEscape(value)
+	}
+	return value
+}
+
+// pathEscape path-escapes each `/`-separated segment of value.
+func pathEscape(value string) string {
+	segments := strings.Split(value, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// inURLPath reports whether a URL that starts with prefix has reached its
+// path, i.e. a `/` follows the scheme and host.
+func inURLPath(prefix string) bool {
+	if _, rest, ok := strings.Cut(prefix, "://"); ok {
+		return strings.Contains(rest, "/")
+	}
+	return strings.Contains(prefix, "/")
+}
+
+// expandPathParams fills the RFC 6570 `{name}` and `{+name}` expressions of
+// a URL template. `{name}` is escaped as a single path segment, while
+// `{+name}` keeps reserved characters such as `/`. Expressions naming an
+// unknown parameter are left in place.
+func (r *Runner) expandPathParams(rawURL string, pathParams map[string]string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(rawURL, "{")
+		if start == -1 {
+			b.WriteString(rawURL)
+			return b.String()
+		}
+		// Leave {{variables}} to substitute
+		if strings.HasPrefix(rawURL[start:], "{{") {
+			end := strings.Index(rawURL[start:], "}}")
+			if end == -1 {
+				b.WriteString(rawURL)
+				return b.String()
+			}
+			b.WriteString(rawURL[:start+end+2])
+			rawURL = rawURL[start+end+2:]
+			continue
+		}
+		end := strings.Index(rawURL[start:], "}")
+		if end == -1 {
+			b.WriteString(rawURL)
+			return b.String()
+		}
+
+		b.WriteString(rawURL[:start])
+		expr := rawURL[start+1 : start+end]
+		rawURL = rawURL[start+end+1:]
+
+		name, reserved := strings.CutPrefix(expr, "+")
+		value, ok := pathParams[name]
+		if !ok {
+			b.WriteString("{" + expr + "}")
+			continue
+		}
+		value = r.substitute(value)
+		if reserved {
+			b.WriteString(reservedEscape(value))
+		} else {
+			b.WriteString(url.PathEscape(value))
+		}
+	}
+}
+
+// reservedEscape percent-encodes everything except the unreserved and
+// reserved characters of RFC 3986, as RFC 6570 reserved expansion does.
+func reservedEscape(s string) string {
+	const allowed = "-._~:/?#[]@!$&'()*+,;="
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(allowed, c) != -1 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// bodyEscaper picks the escaping for a raw body of the given content type.
+// JSON bodies escape values placed inside strings and urlencoded bodies
+// query-escape values; other bodies are inserted as is.
+func bodyEscaper(contentType string) func(prefix, value string) string {
+	switch {
+	case strings.Contains(contentType, "json"):
+		return jsonEscaper
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		return func(prefix, value string) string { return url.QueryEscape(value) }
+	}
+	return nil
+}
+
+// jsonEscaper escapes value as JSON string content when prefix ends inside a
+// string literal. Values placed outside strings, e.g. `"count": {{n}}`, are
+// inserted as is.
+func jsonEscaper(prefix, value string) string {
+	if !inJSONString(prefix) {
+		return value
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(value)
+	quoted := strings.TrimSuffix(buf.String(), "\n")
+	return quoted[1 : len(quoted)-1]
+}
+
+// inJSONString reports whether the JSON text s ends inside a string literal.
+func inJSONString(s string) bool {
+	in := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if in {
+				i++
+			}
+		case '"':
+			in = !in
+		}
+	}
+	return in
+}
+
+// shellEscaper quotes value for the `sh -c` command of an exec step, so a
+// value such as a token from the state is always a single word and cannot
+// run commands of its own. Values placed inside single or double quotes are
+// escaped for those quotes, all others are wrapped in single quotes.
+func shellEscaper(prefix, value string) string {
+	switch shellQuote(prefix) {
+	case '\'':
+		return strings.ReplaceAll(value, "'", `'\''`)
+	case '"':
+		return shellDoubleQuoteEscaper.Replace(value)
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+var shellDoubleQuoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+
+// shellQuote returns the quote character the shell command s ends inside,
+// or 0 if it ends outside quotes.
+func shellQuote(s string) byte {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		}
+	}
+	return quote
+}
+
+// rawBodyContentType returns the Content-Type set in headers, or guesses one
+// from the body: JSON for bodies starting with `{` or `[`, plain text otherwise.
+func rawBodyContentType(headers map[string]string, body string) string {
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) == "Content-Type" {
+			return v
+		}
+	}
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestURLEscaper(t *testing.T) {
+	tests := []struct {
+		prefix, value, want string
+	}{
+		{"", "http://host", "http://host"},
+		{"http://host", ":8080", ":8080"},
+		{"http://host/users/", "a/b c", "a/b%20c"},
+		{"http://host/users/", "x?y#z", "x%3Fy%23z"},
+		{"http://host/search?q=", "a/b c&d", "a%2Fb+c%26d"},
+		{"http://host/page#", "a b/c", "a%20b/c"},
+		{"/relative/", "x y", "x%20y"},
+	}
+	for _, tt := range tests {
+		if got := urlEscaper(tt.prefix, tt.value); got != tt.want {
+			t.Errorf("urlEscaper(%q, %q) = %q, want %q", tt.prefix, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSubstituteURL(t *testing.T) {
+	r := newTestRunner(t)
+	r.Environment["host"] = "http://api.test"
+	r.Environment["base"] = "http://api.test/v1"
+	r.Environment["path"] = "/v1/users"
+	r.Environment["route"] = "users/42"
+	r.Environment["q"] = "a/b c"
+
+	tests := []struct {
+		url, want string
+	}{
+		{"{{host}}{{path}}", "http://api.test/v1/users"},
+		{"{{base}}/{{route}}", "http://api.test/v1/users/42"},
+		{"{{host}}/{{route}}?q={{q}}", "http://api.test/users/42?q=a%2Fb+c"},
+		{"{{host}}/search/{{q}}", "http://api.test/search/a/b%20c"},
+		{"{{host}}/items/{id}", "http://api.test/items/a%2Fb%20c"},
+	}
+	for _, tt := range tests {
+		if got := r.substituteURL(tt.url, map[string]string{"id": "{{q}}"}); got != tt.want {
+			t.Errorf("substituteURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestJSONEscaper(t *testing.T) {
+	tests := []struct {
+		prefix, value, want string
+	}{
+		{`{"name": "`, `say "hi"`, `say \"hi\"`},
+		{`{"name": "`, "a\nb <b>", `a\nb <b>`},
+		{`{"count": `, "5", "5"},
+		{`{"a": "x\"", "b": `, "true", "true"},
+	}
+	for _, tt := range tests {
+		if got := jsonEscaper(tt.prefix, tt.value); got != tt.want {
+			t.Errorf("jsonEscaper(%q, %q) = %q, want %q", tt.prefix, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestReservedEscape(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"a/b c?d", "a/b%20c?d"},
+		{"x=1&y=#2", "x=1&y=#2"},
+		{"ü", "%C3%BC"},
+	}
+	for _, tt := range tests {
+		if got := reservedEscape(tt.value); got != tt.want {
+			t.Errorf("reservedEscape(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestShellEscaper(t *testing.T) {
+	tests := []struct {
+		prefix, value, want string
+	}{
+		{"echo ", "a b; rm x", `'a b; rm x'`},
+		{"echo ", "it's", `'it'\''s'`},
+		{"echo '", "it's", `it'\''s`},
+		{`echo "`, "$(id) `id` \"q\"", "\\$(id) \\`id\\` \\\"q\\\""},
+		{`echo "it's" `, "x", `'x'`},
+		{`echo \' `, "x", `'x'`},
+	}
+	for _, tt := range tests {
+		if got := shellEscaper(tt.prefix, tt.value); got != tt.want {
+			t.Errorf("shellEscaper(%q, %q) = %q, want %q", tt.prefix, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExecQuotesSubstitutedValues(t *testing.T) {
+	r := newTestRunner(t)
+	r.Environment["token"] = "x'; echo injected; echo '"
+
+	if err := r.runExec(ExecStep{Name: "check", Command: "printf %s {{token}}"}); err != nil {
+		t.Fatalf("runExec: %v", err)
+	}
+	out := r.State["check"].(map[string]interface{})["stdout"]
+	if out != r.Environment["token"] {
+		t.Errorf("stdout = %q, want the token passed as one argument", out)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultExecTimeout bounds commands that do not set their own timeout.
+const defaultExecTimeout = 30 * time.Second
+
+// ExecStep is a group step that runs a shell command.
+type ExecStep struct {
+	Name         string            `yaml:"name"`
+	Command      string            `yaml:"command"`
+	Dir          string            `yaml:"dir"`
+	Env          map[string]string `yaml:"env"`
+	Timeout      time.Duration     `yaml:"timeout"`
+	AllowFailure bool              `yaml:"allow_failure"`
+}
+
+// UnmarshalYAML accepts either a bare command string or a full mapping.
+func (e *ExecStep) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		e.Command = value.Value
+		return nil
+	}
+	type plain ExecStep
+	return value.Decode((*plain)(e))
+}
+
+// execResult is the outcome of a command as stored in state.
+type execResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// runCommand runs a command through `sh -c` and captures its output. A
+// non-zero exit code is reported through ExitCode, not as an error.
+func runCommand(command, dir string, env map[string]string, timeout time.Duration) (execResult, error) {
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	cmd.WaitDelay = time.Second
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	res := execResult{
+		Stdout: strings.TrimRight(stdout.String(), "\r\n"),
+		Stderr: strings.TrimRight(stderr.String(), "\r\n"),
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return res, fmt.Errorf("command timed out after %v", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.ExitCode()
+		return res, nil
+	}
+	return res, err
+}
+
+// runExec executes an exec group step and stores its output in state under
+// the step name. Values substituted into the command are shell-quoted.
+func (r *Runner) runExec(step ExecStep) error {
+	name := step.Name
+	if name == "" {
+		name = "exec"
+	}
+	command := r.substituteEscaped(step.Command, shellEscaper)
+	env := make(map[string]string, len(step.Env))
+	for k, v := range step.Env {
+		env[k] = r.substitute(v)
+	}
+
+	fmt.Printf("\n%s--- %s[%s]%s exec ---%s\n", colorBold, colorCyan, name, colorReset, colorReset)
+	fmt.Printf("%s$%s %s\n", colorPurple, colorReset, command)
+
+	startTime := time.Now()
+	res, err := runCommand(command, r.substitute(step.Dir), env, step.Timeout)
+	if err != nil {
+		return fmt.Errorf("%sexec %q failed: %v%s", colorRed, name, err, colorReset)
+	}
+	duration := time.Since(startTime)
+
+	r.State[name] = map[string]interface{}{
+		"stdout":    res.Stdout,
+		"stderr":    res.Stderr,
+		"exit_code": res.ExitCode,
+	}
+	r.saveState()
+
+	statusColor := colorGreen
+	if res.ExitCode != 0 {
+		statusColor = colorRed
+	}
+	fmt.Printf("Exit code: %s%d%s (took %s%v%s)\n", statusColor, res.ExitCode, colorReset, colorYellow, duration.Round(time.Millisecond), colorReset)
+	if res.Stdout != "" {
+		fmt.Printf("\n%sStdout:%s\n%s\n", colorBold, colorReset, res.Stdout)
+	}
+	if res.Stderr != "" {
+		fmt.Printf("\n%sStderr:%s\n%s\n", colorBold, colorReset, res.Stderr)
+	}
+
+	if res.ExitCode != 0 && !step.AllowFailure {
+		return fmt.Errorf("%sexec %q exited with code %d%s", colorRed, name, res.ExitCode, colorReset)
+	}
+	return nil
+}
+
+// resolveExec resolves a `{{$exec: command}}` variable to the command's
+// standard output. Results are cached for the duration of the run.
+func (r *Runner) resolveExec(command string) (string, bool) {
+	command = strings.TrimSpace(command)
+	if out, ok := r.execCache[command]; ok {
+		return out, true
+	}
+
+	res, err := runCommand(command, "", nil, defaultExecTimeout)
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("exited with code %d: %s", res.ExitCode, res.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sWarning: $exec %q failed: %v%s\n", colorYellow, command, err, colorReset)
+		return "", false
+	}
+
+	if r.execCache == nil {
+		r.execCache = make(map[string]string)
+	}
+	r.execCache[command] = res.Stdout
+	return res.Stdout, true
+}
@@ -2,70 +2,573 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-faker/faker/v4"
 )
 
-// Generator is a function that returns a random string.
-type Generator func() string
+// rng drives the built-in generators and faker, reading from rngSource. It
+// is reseeded by seedGenerators so that a run can be reproduced with the
+// same seed.
+var (
+	rngSource = rand.NewSource(time.Now().UnixNano())
+	rng       = rand.New(rngSource)
+)
+
+// seedGenerators makes all generator output deterministic for seed, including
+// faker's UUIDs, which otherwise read from crypto/rand.
+func seedGenerators(seed int64) {
+	useSource(rand.NewSource(seed), nil)
+}
+
+// pinSeed seeds the generators with seed until the returned function is
+// called, which restores the previous source where it left off.
+func pinSeed(seed int64) (restore func()) {
+	prevSource, prevRng := rngSource, rng
+	seedGenerators(seed)
+	return func() { useSource(prevSource, prevRng) }
+}
+
+// useSource makes src drive the generators and faker. r reads from src, or
+// is created when nil.
+func useSource(src rand.Source, r *rand.Rand) {
+	if r == nil {
+		r = rand.New(src)
+	}
+	rngSource, rng = src, r
+	faker.SetRandomSource(src)
+	faker.SetCryptoSource(rng)
+}
+
+// Generator is a function that returns a random string. Arguments come from
+// the `[[tag: a, b]]` form and are empty for a bare `[[tag]]`.
+type Generator func(args []string) (string, error)
 
-// Generators is a map of generator functions that can be used for variable substitution.
+// Generators is a map of generator functions that can be used for variable
+// substitution. Locale-aware generators are in localeGenerators.
 var Generators = map[string]Generator{
 	"int":                  randomInt,
-	"phone":                randomPhoneNumber,
-	"datetime":             randomDateTime,
-	"lat":                  randomLat,
-	"long":                 randomLong,
-	"real_address":         randomRealAddress,
-	"cc_number":            randomCCNumber,
-	"cc_type":              randomCCType,
-	"email":                randomEmail,
-	"domain_name":          randomDomainName,
-	"ipv4":                 randomIPV4,
-	"ipv6":                 randomIPV6,
-	"password":             randomPassword,
-	"jwt":                  randomJWT,
-	"phone_number":         randomPhoneNumber,
-	"mac_address":          randomMacAddress,
-	"url":                  randomURL,
-	"username":             randomUsername,
-	"toll_free_number":     randomTollFreeNumber,
-	"e_164_phone_number":   randomE164PhoneNumber,
-	"title_male":           randomTitleMale,
-	"title_female":         randomTitleFemale,
-	"first_name":           randomFirstName,
-	"first_name_male":      randomFirstNameMale,
-	"first_name_female":    randomFirstNameFemale,
-	"last_name":            randomLastName,
-	"name":                 randomName,
-	"unix_time":            randomUnixTime,
+	"datetime":             noArgs(randomDateTime),
+	"lat":                  noArgs(randomLat),
+	"long":                 noArgs(randomLong),
+	"cc_number":            noArgs(randomCCNumber),
+	"cc_type":              noArgs(randomCCType),
+	"email":                noArgs(randomEmail),
+	"domain_name":          noArgs(randomDomainName),
+	"ipv4":                 noArgs(randomIPV4),
+	"ipv6":                 noArgs(randomIPV6),
+	"password":             noArgs(randomPassword),
+	"jwt":                  noArgs(randomJWT),
+	"mac_address":          noArgs(randomMacAddress),
+	"url":                  noArgs(randomURL),
+	"username":             noArgs(randomUsername),
+	"toll_free_number":     noArgs(randomTollFreeNumber),
+	"e_164_phone_number":   noArgs(randomE164PhoneNumber),
+	"title_male":           noArgs(randomTitleMale),
+	"title_female":         noArgs(randomTitleFemale),
+	"unix_time":            noArgs(randomUnixTime),
 	"date":                 randomDate,
-	"time":                 randomTime,
-	"month_name":           randomMonthName,
-	"year":                 randomYear,
-	"day_of_week":          randomDayOfWeek,
-	"day_of_month":         randomDayOfMonth,
-	"timestamp":            randomTimestamp,
-	"century":              randomCentury,
-	"timezone":             randomTimeZone,
-	"time_period":          randomTimePeriod,
-	"word":                 randomWord,
-	"sentence":             randomSentence,
-	"paragraph":            randomParagraph,
-	"currency":             randomCurrency,
-	"amount":               randomAmount,
-	"amount_with_currency": randomAmountWithCurrency,
-	"uuid_hyphenated":      randomUUIDHyphenated,
-	"uuid_digit":           randomUUIDDigit,
-}
-
-func randomInt() string {
-	return fmt.Sprintf("%d", rand.Intn(1000000))
-}
-
+	"time":                 noArgs(randomTime),
+	"month_name":           noArgs(randomMonthName),
+	"year":                 noArgs(randomYear),
+	"day_of_week":          noArgs(randomDayOfWeek),
+	"day_of_month":         noArgs(randomDayOfMonth),
+	"timestamp":            noArgs(randomTimestamp),
+	"century":              noArgs(randomCentury),
+	"timezone":             noArgs(randomTimeZone),
+	"time_period":          noArgs(randomTimePeriod),
+	"word":                 noArgs(randomWord),
+	"sentence":             noArgs(randomSentence),
+	"paragraph":            noArgs(randomParagraph),
+	"amount":               noArgs(randomAmount),
+	"amount_with_currency": noArgs(randomAmountWithCurrency),
+	"uuid_hyphenated":      noArgs(randomUUIDHyphenated),
+	"uuid_digit":           noArgs(randomUUIDDigit),
+	"oneof":                oneOf,
+	"float":                randomFloat,
+	"string":               randomString,
+	"regex":                randomRegex,
+	"bool":                 noArgs(randomBool),
+	"words":                randomWords,
+}
+
+// builtinGenerators returns Generators together with the locale-aware
+// generators bound to locale, for a runner to add its plugin and
+// user-defined generators to.
+func builtinGenerators(locale string) map[string]Generator {
+	gens := make(map[string]Generator, len(Generators)+len(localeGenerators))
+	for name, gen := range Generators {
+		gens[name] = gen
+	}
+	for name, bind := range localeGenerators {
+		gens[name] = bind(locale)
+	}
+	return gens
+}
+
+// noArgs adapts a generator that takes no arguments.
+func noArgs(fn func() string) Generator {
+	return func(args []string) (string, error) {
+		if len(args) > 0 {
+			return "", fmt.Errorf("takes no arguments")
+		}
+		return fn(), nil
+	}
+}
+
+// bindingPattern matches the `as name` suffix of a tag, as in
+// `[[email as signup_email]]`.
+var bindingPattern = regexp.MustCompile(`^(.*?)\s+as\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// splitBinding separates a trailing `as name` from a generator tag.
+func splitBinding(tag string) (string, string) {
+	if m := bindingPattern.FindStringSubmatch(tag); m != nil {
+		return m[1], m[2]
+	}
+	return tag, ""
+}
+
+// parseGeneratorTag splits `name: a, b` into the name and its arguments.
+func parseGeneratorTag(tag string) (string, []string) {
+	name, rest, found := strings.Cut(tag, ":")
+	name = strings.TrimSpace(name)
+	if !found {
+		return name, nil
+	}
+	return name, splitArgs(rest)
+}
+
+// splitArgs splits a comma-separated argument list. Arguments may be quoted
+// with single or double quotes to include commas or surrounding spaces; a
+// quote inside an argument, as in `don't`, is kept as is.
+func splitArgs(s string) []string {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	quoted := false
+
+	flush := func() {
+		arg := current.String()
+		if !quoted {
+			arg = strings.TrimSpace(arg)
+		}
+		args = append(args, arg)
+		current.Reset()
+		quoted = false
+	}
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote != 0 && c == '\\' && i+1 < len(runes) && runes[i+1] == quote:
+			current.WriteRune(quote)
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(c)
+		case (c == '"' || c == '\'') && !quoted && strings.TrimSpace(current.String()) == "":
+			current.Reset()
+			quote = c
+			quoted = true
+		case c == ',':
+			flush()
+		case quoted && c == ' ':
+			// Ignore spaces between a closing quote and the next comma.
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if strings.TrimSpace(s) != "" || len(args) > 0 {
+		flush()
+	}
+	return args
+}
+
+// intArg parses the argument at index i, falling back to def when absent.
+func intArg(args []string, i, def int) (int, error) {
+	if i >= len(args) || args[i] == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil {
+		return 0, fmt.Errorf("argument %d: %q is not an integer", i+1, args[i])
+	}
+	return n, nil
+}
+
+// floatArg parses the argument at index i, falling back to def when absent.
+func floatArg(args []string, i int, def float64) (float64, error) {
+	if i >= len(args) || args[i] == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(args[i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("argument %d: %q is not a number", i+1, args[i])
+	}
+	return f, nil
+}
+
+// randomInt returns an integer in [min, max], by default [0, 1000000).
+func randomInt(args []string) (string, error) {
+	if len(args) == 0 {
+		return fmt.Sprintf("%d", rng.Intn(1000000)), nil
+	}
+	min, err := intArg(args, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	max, err := intArg(args, 1, 1000000)
+	if err != nil {
+		return "", err
+	}
+	if max < min {
+		return "", fmt.Errorf("max %d is less than min %d", max, min)
+	}
+	// The span may not fit in an int, e.g. for the full int range
+	span := uint64(max) - uint64(min)
+	if span >= math.MaxInt {
+		return "", fmt.Errorf("range %d to %d is too large", min, max)
+	}
+	return fmt.Sprintf("%d", min+rng.Intn(int(span)+1)), nil
+}
+
+// randomFloat returns a number in [min, max) with the given decimals,
+// by default in [0, 1) with 2 decimals.
+func randomFloat(args []string) (string, error) {
+	min, err := floatArg(args, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	max, err := floatArg(args, 1, 1)
+	if err != nil {
+		return "", err
+	}
+	decimals, err := intArg(args, 2, 2)
+	if err != nil {
+		return "", err
+	}
+	if max < min {
+		return "", fmt.Errorf("max %v is less than min %v", max, min)
+	}
+	return strconv.FormatFloat(min+rng.Float64()*(max-min), 'f', decimals, 64), nil
+}
+
+var charsets = map[string]string{
+	"alnum":   "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"alpha":   "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"numeric": "0123456789",
+	"hex":     "0123456789abcdef",
+	"lower":   "abcdefghijklmnopqrstuvwxyz",
+	"upper":   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+}
+
+// randomString returns a string of the given length (default 16) drawn
+// from a named charset (default alnum).
+func randomString(args []string) (string, error) {
+	length, err := intArg(args, 0, 16)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 {
+		return "", fmt.Errorf("length %d is negative", length)
+	}
+	name := "alnum"
+	if len(args) > 1 && args[1] != "" {
+		name = args[1]
+	}
+	charset, ok := charsets[name]
+	if !ok {
+		return "", fmt.Errorf("unknown charset %q", name)
+	}
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = charset[rng.Intn(len(charset))]
+	}
+	return string(b), nil
+}
+
+// maxRegexRepeat caps unbounded repetitions such as `*` and `+`.
+const maxRegexRepeat = 10
+
+// randomRegex returns a string matching the given regular expression.
+func randomRegex(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expects exactly one pattern")
+	}
+	re, err := syntax.Parse(args[0], syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern %q: %w", args[0], err)
+	}
+	var b strings.Builder
+	writeRegex(&b, re.Simplify())
+	return b.String(), nil
+}
+
+func writeRegex(b *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		b.WriteRune(randomRuneFromClass(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteRune(rune(0x21 + rng.Intn(0x7e-0x21+1)))
+	case syntax.OpCapture:
+		writeRegex(b, re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			writeRegex(b, sub)
+		}
+	case syntax.OpAlternate:
+		writeRegex(b, re.Sub[rng.Intn(len(re.Sub))])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		min, max := re.Min, re.Max
+		switch re.Op {
+		case syntax.OpStar:
+			min, max = 0, maxRegexRepeat
+		case syntax.OpPlus:
+			min, max = 1, maxRegexRepeat
+		case syntax.OpQuest:
+			min, max = 0, 1
+		}
+		if max < 0 {
+			max = min + maxRegexRepeat
+		}
+		for i := min + rng.Intn(max-min+1); i > 0; i-- {
+			writeRegex(b, re.Sub[0])
+		}
+	}
+}
+
+// randomRuneFromClass picks a rune from a character class, preferring
+// printable ASCII so negated classes produce readable output.
+func randomRuneFromClass(ranges []rune) rune {
+	var printable []rune
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		if lo < 0x20 {
+			lo = 0x20
+		}
+		if hi > 0x7e {
+			hi = 0x7e
+		}
+		if lo <= hi {
+			printable = append(printable, lo, hi)
+		}
+	}
+	if len(printable) > 0 {
+		ranges = printable
+	}
+
+	total := 0
+	for i := 0; i+1 < len(ranges); i += 2 {
+		total += int(ranges[i+1]-ranges[i]) + 1
+	}
+	if total == 0 {
+		return '?'
+	}
+	n := rng.Intn(total)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		size := int(ranges[i+1]-ranges[i]) + 1
+		if n < size {
+			return ranges[i] + rune(n)
+		}
+		n -= size
+	}
+	return ranges[0]
+}
+
+func randomBool() string {
+	return strconv.FormatBool(rng.Intn(2) == 1)
+}
+
+// randomWords returns n space-separated words, by default 3.
+func randomWords(args []string) (string, error) {
+	n, err := intArg(args, 0, 3)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", fmt.Errorf("count %d is negative", n)
+	}
+	words := make([]string, n)
+	for i := range words {
+		words[i] = faker.Word()
+	}
+	return strings.Join(words, " "), nil
+}
+
+// oneOf picks one of its arguments. An argument written as `value=N`, with
+// N an integer, is picked with weight N instead of 1.
+func oneOf(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("expects at least one value")
+	}
+	values := make([]string, len(args))
+	weights := make([]int, len(args))
+	total := 0
+	for i, arg := range args {
+		values[i], weights[i] = arg, 1
+		if value, weight, found := strings.Cut(arg, "="); found {
+			if w, err := strconv.Atoi(strings.TrimSpace(weight)); err == nil && w >= 0 {
+				values[i], weights[i] = strings.TrimSpace(value), w
+			}
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return "", fmt.Errorf("all weights are zero")
+	}
+	n := rng.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return values[i], nil
+		}
+		n -= w
+	}
+	return values[len(values)-1], nil
+}
+
+// randomDate returns a random date. With arguments it returns a time between
+// two points relative to now (e.g. `-7d, +7d`) or absolute dates
+// (`2024-01-01`), formatted with an optional layout (default 2006-01-02).
+func randomDate(args []string) (string, error) {
+	if len(args) == 0 {
+		return faker.Date(), nil
+	}
+	now := time.Now()
+	from, err := parseTimeArg(args[0], now)
+	if err != nil {
+		return "", err
+	}
+	to := now
+	if len(args) > 1 && args[1] != "" {
+		if to, err = parseTimeArg(args[1], now); err != nil {
+			return "", err
+		}
+	}
+	if to.Before(from) {
+		from, to = to, from
+	}
+	layout := time.DateOnly
+	if len(args) > 2 && args[2] != "" {
+		layout = timeLayout(args[2])
+	}
+	// Picked in whole seconds, as a time.Duration only spans about 292 years
+	span := to.Unix() - from.Unix()
+	if span < 0 || span == math.MaxInt64 {
+		return "", fmt.Errorf("date range is too large")
+	}
+	t := time.Unix(from.Unix()+rng.Int63n(span+1), 0).In(from.Location())
+	return formatTime(t, layout), nil
+}
+
+// parseTimeArg parses an offset from now (`-7d`, `+2h30m`, `now`) or an
+// absolute date or RFC 3339 timestamp.
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	if d, err := parseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// parseDuration extends time.ParseDuration with day (d) and week (w) units,
+// e.g. `7d`, `-1w` or `1d12h`.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+
+	var total time.Duration
+	for s != "" {
+		i := 0
+		for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		if s[i] != 'd' && s[i] != 'w' {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return 0, err
+			}
+			return sign * (total + d), nil
+		}
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, err
+		}
+		unit := 24 * time.Hour
+		if s[i] == 'w' {
+			unit *= 7
+		}
+		total += time.Duration(n * float64(unit))
+		s = s[i+1:]
+	}
+	return sign * total, nil
+}
+
+// timeLayouts maps layout names to Go time layouts.
+var timeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC822":      time.RFC822,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+	"unix":        "unix",
+	"unix_ms":     "unix_ms",
+}
+
+// timeLayout resolves a layout name, or returns s as a literal Go layout.
+func timeLayout(s string) string {
+	if layout, ok := timeLayouts[s]; ok {
+		return layout
+	}
+	return s
+}
+
+// formatTime formats t with a Go layout or the `unix`/`unix_ms` pseudo-layouts.
+func formatTime(t time.Time, layout string) string {
+	switch layout {
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unix_ms":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return t.Format(layout)
+}
+
+// randomName composes the name itself, as faker.Name picks the gender once
+// per process from an unseeded source.
 func randomName() string {
-	return faker.Name()
+	if rng.Intn(2) == 0 {
+		return fmt.Sprintf("%s %s %s", faker.TitleFemale(), faker.FirstNameFemale(), faker.LastName())
+	}
+	return fmt.Sprintf("%s %s %s", faker.TitleMale(), faker.FirstNameMale(), faker.LastName())
 }
 
 func randomEmail() string {
@@ -177,10 +680,6 @@ func randomUnixTime() string {
 	return fmt.Sprintf("%d", faker.UnixTime())
 }
 
-func randomDate() string {
-	return faker.Date()
-}
-
 func randomTime() string {
 	return faker.TimeString()
 }
@@ -226,7 +725,7 @@ func randomCurrency() string {
 }
 
 func randomAmount() string {
-	return fmt.Sprintf("%d.%02d", rand.Intn(1000), rand.Intn(100))
+	return fmt.Sprintf("%d.%02d", rng.Intn(1000), rng.Intn(100))
 }
 
 func randomAmountWithCurrency() string {
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{" 1, 10 ", []string{"1", "10"}},
+		{`'a, b', "c"`, []string{"a, b", "c"}},
+		{`" padded ", x`, []string{" padded ", "x"}},
+		{`'it\'s', y`, []string{"it's", "y"}},
+		{"don't, do", []string{"don't", "do"}},
+		{`say "hi", bye`, []string{`say "hi"`, "bye"}},
+	}
+	for _, tt := range tests {
+		if got := splitArgs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReplaceGeneratorTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		tags []string
+	}{
+		{"[[email]] and [[int: 1, 5]]", []string{"email", "int: 1, 5"}},
+		{"[[oneof: ']]', x]] tail [[uuid_digit]]", []string{"oneof: ']]', x", "uuid_digit"}},
+		// An apostrophe inside an argument does not open a quote
+		{"[[oneof: don't, do]] then [[email]]", []string{"oneof: don't, do", "email"}},
+		{"[[unclosed", nil},
+	}
+	for _, tt := range tests {
+		var tags []string
+		out := replaceGeneratorTags(tt.in, func(prefix, match, tag string) string {
+			tags = append(tags, tag)
+			return "X"
+		})
+		if !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("replaceGeneratorTags(%q) visited %q, want %q", tt.in, tags, tt.tags)
+		}
+		if len(tt.tags) > 0 && strings.Contains(out, "[[") {
+			t.Errorf("replaceGeneratorTags(%q) = %q, left a tag in place", tt.in, out)
+		}
+	}
+}
+
+func TestRandomIntRange(t *testing.T) {
+	seedGenerators(1)
+	for i := 0; i < 100; i++ {
+		s, err := randomInt([]string{"-3", "3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := strconv.Atoi(s); n < -3 || n > 3 {
+			t.Fatalf("randomInt(-3, 3) = %d", n)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"5", "1"},
+		{"-9223372036854775808", "9223372036854775807"},
+		{"-1", "9223372036854775807"},
+	} {
+		if _, err := randomInt(args); err == nil {
+			t.Errorf("randomInt(%q) did not fail", args)
+		}
+	}
+}
+
+func TestGeneratorCounts(t *testing.T) {
+	tests := []struct {
+		gen     Generator
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{randomString, []string{"-1"}, "", true},
+		{randomWords, []string{"-1"}, "", true},
+		{randomString, []string{"0"}, "", false},
+		{randomWords, []string{"0"}, "", false},
+	}
+	for i, tt := range tests {
+		got, err := tt.gen(tt.args)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("case %d: got %q, %v, want %q (error %v)", i, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRandomDateWideRange(t *testing.T) {
+	seedGenerators(1)
+	for i := 0; i < 100; i++ {
+		s, err := randomDate([]string{"1000-01-01", "2900-12-31"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Year() < 1000 || d.Year() > 2900 {
+			t.Fatalf("randomDate = %s, outside the range", s)
+		}
+	}
+}
+
+func TestRequestSeed(t *testing.T) {
+	r := newConfigRunner(t, `
+environments:
+  test: {}
+requests:
+  a: {method: POST, url: "{{host}}/", json: {v: "[[string: 16]]"}}
+  b: {method: POST, url: "{{host}}/", json: {v: "[[string: 16]]"}}
+  pinned: {method: POST, url: "{{host}}/", seed: 7, json: {v: "[[string: 16]]"}}
+`)
+	run := func(seed int64, names ...string) map[string]interface{} {
+		r.SetSeed(seed)
+		values := make(map[string]interface{})
+		for _, name := range names {
+			if err := r.runRequest(name, nil); err != nil {
+				t.Fatal(err)
+			}
+			values[name] = generated(r, name)[0]
+		}
+		return values
+	}
+
+	plain := run(1, "a", "b")
+	seeded := run(1, "a", "pinned", "b")
+	if seeded["a"] != plain["a"] || seeded["b"] != plain["b"] {
+		t.Errorf("values with a seeded request between = %v, without = %v", seeded, plain)
+	}
+	if other := run(2, "pinned"); other["pinned"] != seeded["pinned"] {
+		t.Errorf("pinned request generated %v with run seed 2 and %v with run seed 1", other["pinned"], seeded["pinned"])
+	}
+	if again := run(1, "a", "b"); again["a"] != plain["a"] || again["b"] != plain["b"] {
+		t.Errorf("run seed 1 generated %v, then %v", plain, again)
+	}
+}
+
+func TestSetSeedReproducible(t *testing.T) {
+	r := newTestRunner(t)
+	tags := "[[uuid_hyphenated]] [[email]] [[int]] [[name]] [[string: 8]] [[oneof: a, b, c]]"
+	generate := func(seed int64) string {
+		r.SetSeed(seed)
+		return r.substitute(tags) + r.substitute(tags)
+	}
+
+	first := generate(42)
+	if again := generate(42); again != first {
+		t.Errorf("seed 42 generated %q, then %q", first, again)
+	}
+	if other := generate(43); other == first {
+		t.Error("seeds 42 and 43 generated the same values")
+	}
+	if seed := r.State["$run"].(map[string]interface{})["seed"]; seed != int64(43) {
+		t.Errorf("$run seed = %v, want 43", seed)
+	}
+}
+
+func TestSplitBinding(t *testing.T) {
+	tests := []struct {
+		tag, wantTag, wantName string
+	}{
+		{"email", "email", ""},
+		{"email as signup_email", "email", "signup_email"},
+		{"int: 1, 5 as n", "int: 1, 5", "n"},
+		{"oneof: 'a as b', c", "oneof: 'a as b', c", ""},
+		{"email as 1bad", "email as 1bad", ""},
+	}
+	for _, tt := range tests {
+		tag, name := splitBinding(tt.tag)
+		if tag != tt.wantTag || name != tt.wantName {
+			t.Errorf("splitBinding(%q) = %q, %q, want %q, %q", tt.tag, tag, name, tt.wantTag, tt.wantName)
+		}
+	}
+}
+
+func TestBoundValueReuse(t *testing.T) {
+	r := newTestRunner(t)
+	got := r.substitute("[[int: 100, 999 as code]]-{{code}}")
+	code, _, _ := strings.Cut(got, "-")
+	if got != code+"-"+code {
+		t.Errorf("substitute = %q, want the bound value repeated", got)
+	}
+	if r.Vars["code"] != code {
+		t.Errorf("vars code = %v, want %s", r.Vars["code"], code)
+	}
+	if again := r.substitute("{{code}}"); again != code {
+		t.Errorf("{{code}} in a later substitution = %q, want %s", again, code)
+	}
+}
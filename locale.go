@@ -0,0 +1,274 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// ibanFallbackLocale is used by `[[iban]]` when neither the call nor the
+// environment names a locale with an IBAN format.
+const ibanFallbackLocale = "de_DE"
+
+// localeGenerators are the locale-aware generators. Each is bound to the
+// locale of a run, set from the environment's `locale` key, which is used
+// when a call does not name one.
+var localeGenerators = map[string]func(defaultLocale string) Generator{
+	"phone":             localized(randomPhoneNumber, localePhone),
+	"real_address":      localized(randomRealAddress, localeAddress),
+	"phone_number":      localized(randomPhoneNumber, localePhone),
+	"first_name":        localized(randomFirstName, localeFirstName(-1)),
+	"first_name_male":   localized(randomFirstNameMale, localeFirstName(1)),
+	"first_name_female": localized(randomFirstNameFemale, localeFirstName(0)),
+	"last_name":         localized(randomLastName, localeLastName),
+	"name":              localized(randomName, localeName),
+	"currency":          localized(randomCurrency, localeCurrency),
+	"address":           localized(nil, localeAddress),
+	"city":              localized(nil, localeCityName),
+	"postcode":          localized(nil, localePostcode),
+	"iban":              localizedIBAN,
+}
+
+// locale holds the data tables of one locale, loaded from locales/<name>.json.
+type locale struct {
+	code string
+
+	FirstNamesMale   []string    `json:"first_names_male"`
+	FirstNamesFemale []string    `json:"first_names_female"`
+	LastNames        []string    `json:"last_names"`
+	NameFormat       string      `json:"name_format"`
+	Streets          []string    `json:"streets"`
+	Cities           []cityEntry `json:"cities"`
+	AddressFormat    string      `json:"address_format"`
+	NumberFormat     string      `json:"number_format"`
+	PhoneFormats     []string    `json:"phone_formats"`
+	Currency         string      `json:"currency"`
+	IBAN             *struct {
+		Country string `json:"country"`
+		BBAN    string `json:"bban"`
+		Check   string `json:"check"`
+	} `json:"iban"`
+}
+
+type cityEntry struct {
+	Name     string `json:"name"`
+	Region   string `json:"region"`
+	Postcode string `json:"postcode"`
+}
+
+var locales = make(map[string]*locale)
+
+// loadLocale returns the tables of a locale such as `de_DE` (or `de-DE`).
+func loadLocale(name string) (*locale, error) {
+	name = strings.ReplaceAll(name, "-", "_")
+	if l, ok := locales[name]; ok {
+		return l, nil
+	}
+
+	data, err := localeFiles.ReadFile("locales/" + name + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("unknown locale %q", name)
+	}
+	l := &locale{code: name}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("invalid locale %q: %v", name, err)
+	}
+	locales[name] = l
+	return l, nil
+}
+
+// localized adapts a locale-aware generator. The locale is the optional
+// first argument, e.g. `[[phone: de_DE]]`, or the default locale. Without
+// either, fallback is used if given, otherwise en_US.
+func localized(fallback func() string, gen func(l *locale) (string, error)) func(string) Generator {
+	return func(defaultLocale string) Generator {
+		return func(args []string) (string, error) {
+			if len(args) > 1 {
+				return "", fmt.Errorf("takes at most a locale argument")
+			}
+			name := defaultLocale
+			if len(args) == 1 {
+				name = args[0]
+			}
+			if name == "" {
+				if fallback != nil {
+					return fallback(), nil
+				}
+				name = "en_US"
+			}
+
+			l, err := loadLocale(name)
+			if err != nil {
+				return "", err
+			}
+			return gen(l)
+		}
+	}
+}
+
+// localizedIBAN is the iban generator. A locale argument must have an IBAN
+// format; without one, the default locale is used if it has one, and
+// ibanFallbackLocale otherwise, as en_US and ja_JP have none.
+func localizedIBAN(defaultLocale string) Generator {
+	return func(args []string) (string, error) {
+		if len(args) > 1 {
+			return "", fmt.Errorf("takes at most a locale argument")
+		}
+		name := ibanFallbackLocale
+		if len(args) == 1 {
+			name = args[0]
+		} else if defaultLocale != "" {
+			if l, err := loadLocale(defaultLocale); err == nil && l.IBAN != nil {
+				name = defaultLocale
+			}
+		}
+
+		l, err := loadLocale(name)
+		if err != nil {
+			return "", err
+		}
+		return l.iban()
+	}
+}
+
+// pick returns a random element of values.
+func pick(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[rng.Intn(len(values))]
+}
+
+// fillPattern replaces `#` with a random digit and `%` with a digit from 2 to 9.
+func fillPattern(pattern string) string {
+	var b strings.Builder
+	for _, c := range pattern {
+		switch c {
+		case '#':
+			b.WriteByte(byte('0' + rng.Intn(10)))
+		case '%':
+			b.WriteByte(byte('2' + rng.Intn(8)))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (l *locale) firstName(gender int) string {
+	if gender == 0 {
+		return pick(l.FirstNamesFemale)
+	}
+	return pick(l.FirstNamesMale)
+}
+
+func (l *locale) name() string {
+	gender := rng.Intn(2)
+	return strings.NewReplacer("{first}", l.firstName(gender), "{last}", pick(l.LastNames)).Replace(l.NameFormat)
+}
+
+func (l *locale) city() cityEntry {
+	if len(l.Cities) == 0 {
+		return cityEntry{}
+	}
+	return l.Cities[rng.Intn(len(l.Cities))]
+}
+
+func (l *locale) address() string {
+	city := l.city()
+	number := strconv.Itoa(1 + rng.Intn(200))
+	if l.NumberFormat != "" {
+		number = fillPattern(l.NumberFormat)
+	}
+	return strings.NewReplacer(
+		"{number}", number,
+		"{street}", pick(l.Streets),
+		"{city}", city.Name,
+		"{region}", city.Region,
+		"{postcode}", city.Postcode,
+	).Replace(l.AddressFormat)
+}
+
+// iban returns an IBAN with valid ISO 13616 check digits. Locales whose
+// national format fixes the check digits (SI56) get a matching account number.
+func (l *locale) iban() (string, error) {
+	if l.IBAN == nil {
+		return "", fmt.Errorf("locale %s has no IBAN format", l.code)
+	}
+	country := l.IBAN.Country
+	bban := fillPattern(l.IBAN.BBAN)
+
+	if l.IBAN.Check != "" {
+		prefix := bban[:len(bban)-2]
+		for i := 0; i < 100; i++ {
+			candidate := fmt.Sprintf("%s%02d", prefix, i)
+			if ibanMod97(candidate+country+l.IBAN.Check) == 1 {
+				return country + l.IBAN.Check + candidate, nil
+			}
+		}
+		return "", fmt.Errorf("no account number matches check digits %s", l.IBAN.Check)
+	}
+
+	check := 98 - ibanMod97(bban+country+"00")
+	return fmt.Sprintf("%s%02d%s", country, check, bban), nil
+}
+
+// ibanMod97 computes the remainder of an IBAN string rearranged for
+// validation, with letters replaced by 10 to 35.
+func ibanMod97(s string) int {
+	rem := 0
+	for _, c := range s {
+		var digits string
+		if c >= 'A' && c <= 'Z' {
+			digits = strconv.Itoa(int(c-'A') + 10)
+		} else {
+			digits = string(c)
+		}
+		for _, d := range digits {
+			rem = (rem*10 + int(d-'0')) % 97
+		}
+	}
+	return rem
+}
+
+func localeFirstName(gender int) func(l *locale) (string, error) {
+	return func(l *locale) (string, error) {
+		if gender < 0 {
+			return l.firstName(rng.Intn(2)), nil
+		}
+		return l.firstName(gender), nil
+	}
+}
+
+func localeLastName(l *locale) (string, error) {
+	return pick(l.LastNames), nil
+}
+
+func localeName(l *locale) (string, error) {
+	return l.name(), nil
+}
+
+func localePhone(l *locale) (string, error) {
+	return fillPattern(pick(l.PhoneFormats)), nil
+}
+
+func localeAddress(l *locale) (string, error) {
+	return l.address(), nil
+}
+
+func localeCityName(l *locale) (string, error) {
+	return l.city().Name, nil
+}
+
+func localePostcode(l *locale) (string, error) {
+	return l.city().Postcode, nil
+}
+
+func localeCurrency(l *locale) (string, error) {
+	return l.Currency, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// validIBAN checks the length and ISO 13616 check digits of an IBAN.
+func validIBAN(iban string, length int) bool {
+	return len(iban) == length && ibanMod97(iban[4:]+iban[:4]) == 1
+}
+
+func TestIBAN(t *testing.T) {
+	tests := []struct {
+		locale  string
+		args    []string
+		prefix  string
+		length  int
+		wantErr bool
+	}{
+		{"", nil, "DE", 22, false},
+		{"en_US", nil, "DE", 22, false},
+		{"ja_JP", nil, "DE", 22, false},
+		{"sl_SI", nil, "SI56", 19, false},
+		{"", []string{"sl_SI"}, "SI56", 19, false},
+		{"sl_SI", []string{"de-DE"}, "DE", 22, false},
+		{"", []string{"en_US"}, "", 0, true},
+		{"", []string{"xx_XX"}, "", 0, true},
+	}
+	seedGenerators(1)
+	for _, tt := range tests {
+		gen := builtinGenerators(tt.locale)["iban"]
+		for i := 0; i < 20; i++ {
+			iban, err := gen(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("locale %q, args %q: got %s, want an error", tt.locale, tt.args, iban)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("locale %q, args %q: %v", tt.locale, tt.args, err)
+			}
+			if !strings.HasPrefix(iban, tt.prefix) || !validIBAN(iban, tt.length) {
+				t.Fatalf("locale %q, args %q: %s is not a valid %s IBAN", tt.locale, tt.args, iban, tt.prefix)
+			}
+		}
+	}
+}
+
+func TestIBANWithoutLocale(t *testing.T) {
+	r := newTestRunner(t)
+	iban := r.substitute("[[iban]]")
+	if !validIBAN(iban, 22) {
+		t.Errorf("[[iban]] = %q, want a valid IBAN", iban)
+	}
+}
+
+func TestLocaleIsPerRunner(t *testing.T) {
+	r := newTestRunner(t)
+	r.generators = builtinGenerators("de_DE")
+	other := newTestRunner(t)
+	other.generators = builtinGenerators("ja_JP")
+
+	if got := r.substitute("[[currency]]"); got != "EUR" {
+		t.Errorf("de_DE currency = %s, want EUR", got)
+	}
+	if got := other.substitute("[[currency]]"); got != "JPY" {
+		t.Errorf("ja_JP currency = %s, want JPY", got)
+	}
+	if got := other.substitute("[[currency: sl_SI]]"); got != "EUR" {
+		t.Errorf("sl_SI currency = %s, want EUR", got)
+	}
+}
+
+func TestIBANMod97(t *testing.T) {
+	for _, iban := range []string{"DE89370400440532013000", "SI56263300012039086", "GB82WEST12345698765432"} {
+		if !validIBAN(iban, len(iban)) {
+			t.Errorf("%s failed the check digit test", iban)
+		}
+	}
+	if validIBAN("DE88370400440532013000", 22) {
+		t.Error("an IBAN with wrong check digits passed")
+	}
+}
+
+func TestLoadLocale(t *testing.T) {
+	for _, name := range []string{"en_US", "de_DE", "de-DE", "sl_SI", "ja_JP"} {
+		l, err := loadLocale(name)
+		if err != nil {
+			t.Fatalf("loadLocale(%s): %v", name, err)
+		}
+		if len(l.FirstNamesMale) == 0 || len(l.LastNames) == 0 || len(l.Cities) == 0 || len(l.PhoneFormats) == 0 || l.Currency == "" {
+			t.Errorf("locale %s has empty tables", name)
+		}
+	}
+	if _, err := loadLocale("xx_XX"); err == nil {
+		t.Error("loadLocale(xx_XX) did not fail")
+	}
+}
+
+func TestLocalizedGenerators(t *testing.T) {
+	tests := []struct {
+		locale, tag string
+		pattern     string
+	}{
+		{"de_DE", "[[phone]]", `^\+49 \d{2,3} \d{7,8}$`},
+		{"sl_SI", "[[phone_number]]", `^\+386 \d{1,2} [\d ]+$`},
+		{"ja_JP", "[[phone]]", `^\+81 \d{1,2}-\d{4}-\d{4}$`},
+		{"de_DE", "[[postcode]]", `^\d{5}$`},
+		{"", "[[postcode: sl_SI]]", `^\d{4}$`},
+		{"de_DE", "[[address]]", `^\S.* \d+, \d{5} \S`},
+		{"ja_JP", "[[currency]]", `^JPY$`},
+		{"", "[[city]]", `^\S`},
+	}
+	seedGenerators(1)
+	for _, tt := range tests {
+		r := newTestRunner(t)
+		r.generators = builtinGenerators(tt.locale)
+		re := regexp.MustCompile(tt.pattern)
+		for i := 0; i < 20; i++ {
+			if got := r.substitute(tt.tag); !re.MatchString(got) {
+				t.Fatalf("%s in %q = %q, want a match for %s", tt.tag, tt.locale, got, tt.pattern)
+			}
+		}
+	}
+}
+
+func TestLocalizedNameOrder(t *testing.T) {
+	l, err := loadLocale("ja_JP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedGenerators(1)
+	last, _, _ := strings.Cut(l.name(), " ")
+	found := false
+	for _, name := range l.LastNames {
+		found = found || name == last
+	}
+	if !found {
+		t.Errorf("ja_JP name does not start with a last name: %s", last)
+	}
+}
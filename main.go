@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -10,9 +12,12 @@ import (
 	"math/rand"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -37,21 +42,124 @@ const (
 
 // Config represents the Hepi configuration file structure.
 type Config struct {
-	Environments yaml.Node           `yaml:"environments"`
-	Requests     yaml.Node           `yaml:"requests"`
-	Groups       map[string][]string `yaml:"groups"`
+	Include      []string                `yaml:"include"`
+	Environments yaml.Node               `yaml:"environments"`
+	Requests     yaml.Node               `yaml:"requests"`
+	Groups       map[string]Group        `yaml:"groups"`
+	Generators   map[string]GeneratorDef `yaml:"generators"`
+
+	// requestDirs maps request names to the directory of their defining file.
+	requestDirs map[string]string
+	// envDirs maps environment names to the directory of their defining file.
+	envDirs map[string]string
+}
+
+// Group is an ordered list of steps with optional script hooks that run
+// before the first and after the last step.
+type Group struct {
+	Steps []GroupStep `yaml:"steps"`
+	Pre   string      `yaml:"pre"`
+	Post  string      `yaml:"post"`
+}
+
+// UnmarshalYAML accepts either a plain list of steps or a mapping with
+// `steps`, `pre` and `post` keys.
+func (g *Group) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&g.Steps)
+	}
+	type plain Group
+	return value.Decode((*plain)(g))
+}
+
+// GroupStep is a single entry of a group. It names a request and optionally
+// passes template arguments to it, e.g. `- create_user: {role: admin}`, or
+// runs a shell command with `- exec: ...`.
+type GroupStep struct {
+	Request string
+	Args    map[string]interface{}
+	Exec    *ExecStep
+}
+
+// UnmarshalYAML accepts either a plain request name or a single-key mapping
+// of request name to arguments, or of `exec` to a command.
+func (s *GroupStep) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		s.Request = value.Value
+		return nil
+	case yaml.MappingNode:
+		if len(value.Content) != 2 {
+			return fmt.Errorf("line %d: group step must name exactly one request", value.Line)
+		}
+		if value.Content[0].Value == "exec" {
+			s.Exec = &ExecStep{}
+			return value.Content[1].Decode(s.Exec)
+		}
+		s.Request = value.Content[0].Value
+		return value.Content[1].Decode(&s.Args)
+	}
+	return fmt.Errorf("line %d: group step must be a request name or a mapping of request name to arguments", value.Line)
+}
+
+// String returns the step as displayed in the help output.
+func (s GroupStep) String() string {
+	if s.Exec != nil {
+		return fmt.Sprintf("exec(%s)", s.Exec.Command)
+	}
+	if len(s.Args) == 0 {
+		return s.Request
+	}
+	keys := make([]string, 0, len(s.Args))
+	for k := range s.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]string, len(keys))
+	for i, k := range keys {
+		args[i] = fmt.Sprintf("%s=%v", k, s.Args[k])
+	}
+	return fmt.Sprintf("%s(%s)", s.Request, strings.Join(args, ", "))
 }
 
 // Request represents an individual API request definition.
 type Request struct {
+	Extends     string                 `yaml:"extends"`
+	Args        map[string]interface{} `yaml:"args"`
 	Method      string                 `yaml:"method"`
 	URL         string                 `yaml:"url"`
 	Description string                 `yaml:"description"`
 	Headers     map[string]string      `yaml:"headers"`
-	Params      map[string]interface{} `yaml:"params"`
+	Params      QueryParams            `yaml:"params"`
+	QueryStyle  string                 `yaml:"query_style"`
 	JSON        map[string]interface{} `yaml:"json"`
 	Form        map[string]interface{} `yaml:"form"`
-	Files       map[string]string      `yaml:"files"`
+	Body        string                 `yaml:"body"`
+	PathParams  map[string]string      `yaml:"path_params"`
+	Files       map[string]FileList    `yaml:"files"`
+	Parts       []Part                 `yaml:"parts"`
+	Seed        *int64                 `yaml:"seed"`
+	TLSInfo     bool                   `yaml:"tls_info"`
+
+	FollowRedirects *bool           `yaml:"follow_redirects"`
+	MaxRedirects    *int            `yaml:"max_redirects"`
+	Stream          *StreamConfig   `yaml:"stream"`
+	WebSocket       []WebSocketStep `yaml:"websocket"`
+
+	TransportConfig `yaml:",inline"`
+	Pre             string      `yaml:"pre"`
+	Post            string      `yaml:"post"`
+	Auth            *AuthConfig `yaml:"auth"`
+	Assert          []Assertion `yaml:"assert"`
+
+	// dir is the directory relative upload paths are resolved against.
+	dir string
+}
+
+// AuthConfig selects a plugin signer that authenticates the outgoing request.
+type AuthConfig struct {
+	Signer string                 `yaml:"signer"`
+	Args   map[string]interface{} `yaml:"args"`
 }
 
 // Runner manages the execution of API requests.
@@ -62,7 +170,46 @@ type Runner struct {
 	State       map[string]interface{}
 	HTTPClient  *http.Client
 	ShowHeaders bool
-	StateFile   string
+	ShowTLS     bool
+	ShowTiming  bool
+
+	// FollowRedirects and MaxRedirects are the default redirect policy.
+	FollowRedirects bool
+	MaxRedirects    int
+	StateFile       string
+
+	// TLS holds the environment's `tls:` settings, overridden by -tls-* flags.
+	TLS TLSConfig
+	// Transport holds the environment's connection settings, such as its proxy.
+	Transport TransportConfig
+
+	// Args holds the template arguments of the request currently executing.
+	Args map[string]interface{}
+	// Vars holds run-scoped variables, e.g. those set by script hooks.
+	Vars map[string]interface{}
+
+	// Plugins are the external plugins discovered at startup.
+	Plugins []*Plugin
+	// Seed is the generator seed of the current run.
+	Seed int64
+	// Locale is the default locale of locale-aware generators, from the
+	// environment's `locale` key.
+	Locale string
+	// RunID identifies the current run, available as {{$run_id}}.
+	RunID string
+
+	iteration        int
+	iterations       map[string]int
+	generators       map[string]Generator
+	generatorDepth   int
+	objectGenerators map[string]map[string]interface{}
+	generated        []interface{}
+	bound            map[string]interface{}
+	execCache        map[string]string
+	pluginSigners    map[string]*Plugin
+	pluginAssertions map[string]*Plugin
+	tlsConfig        *tls.Config
+	transports       map[string]*http.Transport
 }
 
 func main() {
@@ -71,28 +218,73 @@ func main() {
 	var envName string
 	flag.StringVar(&envName, "env", "", "Environment to use")
 
-	var filePath string
-	flag.StringVar(&filePath, "file", "", "Path to the YAML file")
+	var filePaths stringList
+	flag.Var(&filePaths, "file", "Path to a YAML file or directory (can be repeated)")
 
 	var statePath string
 	flag.StringVar(&statePath, "state", ".hepi.json", "Path to state file")
 	reqNames := flag.String("req", "", "Comma-separated list of request names to execute")
 	groupName := flag.String("group", "", "Group to execute")
 	showHeaders := flag.Bool("headers", false, "Display response headers")
+	showTLS := flag.Bool("tls-info", false, "Display the TLS connection and certificate chain")
+	showTiming := flag.Bool("timing", false, "Display the time spent in each phase of a request")
+	followRedirects := flag.Bool("follow-redirects", true, "Follow redirects")
+	maxRedirects := flag.Int("max-redirects", defaultMaxRedirects, "Maximum number of redirects to follow")
 	timeout := flag.Duration("timeout", 10*time.Second, "Request timeout duration")
+	seed := flag.Int64("seed", 0, "Seed for generators (default: random)")
+
+	var tlsFlags TLSConfig
+	flag.StringVar(&tlsFlags.CAFile, "tls-ca", "", "CA bundle (PEM) to verify servers with")
+	flag.StringVar(&tlsFlags.CertFile, "tls-cert", "", "Client certificate (PEM, or PKCS#12 .p12/.pfx)")
+	flag.StringVar(&tlsFlags.KeyFile, "tls-key", "", "Client certificate key (PEM)")
+	flag.StringVar(&tlsFlags.Password, "tls-password", "", "Password of a PKCS#12 client certificate")
+	flag.StringVar(&tlsFlags.ServerName, "tls-server-name", "", "Server name to send (SNI) and verify")
+	flag.StringVar(&tlsFlags.MinVersion, "tls-min-version", "", "Minimum TLS version (1.0, 1.1, 1.2, 1.3)")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification")
+
+	var resolveFlags, connectToFlags stringList
+	var transportFlags TransportConfig
+	flag.Var(&resolveFlags, "resolve", "Connect to host:port at an address instead, as host:port:address (can be repeated)")
+	flag.Var(&connectToFlags, "connect-to", "Connect to host:port elsewhere, as host:port:connect_host:connect_port (can be repeated)")
+	flag.StringVar(&transportFlags.IPVersion, "ip-version", "", "Only connect over IPv4 (4) or IPv6 (6)")
+	flag.StringVar(&transportFlags.LocalAddress, "local-address", "", "Local IP address to connect from")
 	flag.Parse()
 
-	if filePath == "" {
+	if len(filePaths) == 0 {
 		fmt.Printf("Error: -file is required\n\n")
 		fmt.Printf("Usage: %s -env <environment> -file <file_path> [options]\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	runner, err := NewRunner(filePath, envName, statePath, *timeout)
+	runner, err := NewRunner(filePaths, envName, statePath, *timeout)
 	if err != nil {
 		log.Fatalf("Error: %v", err)
 	}
+	defer runner.Close()
 	runner.ShowHeaders = *showHeaders
+	runner.ShowTLS = *showTLS
+	runner.ShowTiming = *showTiming
+	runner.FollowRedirects = *followRedirects
+	runner.MaxRedirects = *maxRedirects
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "seed":
+			runner.SetSeed(*seed)
+		case "insecure":
+			tlsFlags.InsecureSkipVerify = insecure
+		}
+	})
+	// Paths given on the command line are relative to the working directory
+	for _, path := range []*string{&tlsFlags.CAFile, &tlsFlags.CertFile, &tlsFlags.KeyFile} {
+		if *path != "" {
+			if abs, err := filepath.Abs(*path); err == nil {
+				*path = abs
+			}
+		}
+	}
+	runner.TLS = runner.TLS.merge(tlsFlags)
+	transportFlags.Resolve, transportFlags.ConnectTo = resolveFlags, connectToFlags
+	runner.Transport = runner.Transport.merge(transportFlags)
 
 	if *groupName == "" && *reqNames == "" {
 		fmt.Printf("Error: -group or -req is required\n\n")
@@ -105,6 +297,8 @@ func main() {
 		return
 	}
 
+	fmt.Printf("%sSeed:%s %d\n", colorBold, colorReset, runner.Seed)
+
 	if *groupName != "" {
 		if err := runner.ExecuteGroup(*groupName); err != nil {
 			log.Fatalf("Error: %v", err)
@@ -119,23 +313,18 @@ func main() {
 }
 
 // NewRunner initializes a new Hepi runner.
-func NewRunner(filePath, envName, stateFile string, timeout time.Duration) (*Runner, error) {
-	data, err := os.ReadFile(filePath)
+func NewRunner(filePaths []string, envName, stateFile string, timeout time.Duration) (*Runner, error) {
+	config, err := loadConfig(filePaths)
 	if err != nil {
-		return nil, fmt.Errorf("%sfailed to read file: %w%s", colorRed, err, colorReset)
-	}
-
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("%sfailed to parse YAML: %w%s", colorRed, err, colorReset)
-	}
-
-	if config.Environments.Kind != yaml.MappingNode {
-		return nil, fmt.Errorf("%senvironments must be a mapping%s", colorRed, colorReset)
+		return nil, err
 	}
 
 	selectedEnvName := envName
 	var selectedEnv map[string]interface{}
+	var envSettings struct {
+		TLS             TLSConfig `yaml:"tls"`
+		TransportConfig `yaml:",inline"`
+	}
 
 	if envName != "" {
 		found := false
@@ -147,6 +336,10 @@ func NewRunner(filePath, envName, stateFile string, timeout time.Duration) (*Run
 				if err := config.Environments.Content[i+1].Decode(&selectedEnv); err != nil {
 					return nil, fmt.Errorf("%sfailed to decode environment %q: %w%s", colorRed, envName, err, colorReset)
 				}
+				if err := config.Environments.Content[i+1].Decode(&envSettings); err != nil {
+					return nil, fmt.Errorf("%sfailed to decode environment %q: %w%s", colorRed, envName, err, colorReset)
+				}
+				envSettings.TLS.dir = config.envDirs[name]
 				found = true
 				break
 			}
@@ -156,14 +349,66 @@ func NewRunner(filePath, envName, stateFile string, timeout time.Duration) (*Run
 		}
 	}
 
-	return &Runner{
+	var envLocale string
+	if name, ok := selectedEnv["locale"]; ok {
+		envLocale = fmt.Sprintf("%v", name)
+		if _, err := loadLocale(envLocale); err != nil {
+			return nil, fmt.Errorf("%senvironment %q: %v%s", colorRed, envName, err, colorReset)
+		}
+	}
+
+	plugins, err := loadPlugins(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+
+	runner := &Runner{
 		Config:      config,
 		EnvName:     selectedEnvName,
 		Environment: selectedEnv,
 		State:       loadState(selectedEnvName, stateFile),
+		Vars:        make(map[string]interface{}),
 		StateFile:   stateFile,
 		HTTPClient:  &http.Client{Timeout: timeout},
-	}, nil
+		Locale:      envLocale,
+		generators:  builtinGenerators(envLocale),
+
+		FollowRedirects: true,
+		MaxRedirects:    defaultMaxRedirects,
+		TLS:             envSettings.TLS,
+		Transport:       envSettings.TransportConfig,
+	}
+	runner.RunID = newUUID(7)
+	if err := runner.registerPlugins(plugins); err != nil {
+		runner.Close()
+		return nil, err
+	}
+	if err := runner.registerGenerators(); err != nil {
+		runner.Close()
+		return nil, err
+	}
+	runner.SetSeed(rand.Int63n(1e9))
+	return runner, nil
+}
+
+// SetSeed seeds all generators and starts a new run record in the state
+// under `$run`, where the values generated by each request are kept.
+func (r *Runner) SetSeed(seed int64) {
+	r.Seed = seed
+	seedGenerators(seed)
+	r.State["$run"] = map[string]interface{}{
+		"id":       r.RunID,
+		"seed":     seed,
+		"requests": make(map[string]interface{}),
+	}
+}
+
+// Close releases resources held by the runner, such as plugin processes.
+func (r *Runner) Close() {
+	for _, p := range r.Plugins {
+		p.Close()
+	}
+	r.Plugins = nil
 }
 
 // ExecuteGroup runs all requests in the specified group.
@@ -173,8 +418,26 @@ func (r *Runner) ExecuteGroup(groupName string) error {
 		return fmt.Errorf("%sgroup %q not found%s", colorRed, groupName, colorReset)
 	}
 
-	for _, reqName := range group {
-		if err := r.ExecuteRequests(reqName); err != nil {
+	if group.Pre != "" {
+		if err := r.runScript("pre", group.Pre, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	for _, step := range group.Steps {
+		if step.Exec != nil {
+			if err := r.runExec(*step.Exec); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.runRequest(step.Request, step.Args); err != nil {
+			return err
+		}
+	}
+
+	if group.Post != "" {
+		if err := r.runScript("post", group.Post, nil, nil); err != nil {
 			return err
 		}
 	}
@@ -198,25 +461,13 @@ func (r *Runner) ExecuteRequests(reqNames string) error {
 	}
 
 	for i := 0; i < len(requestsNode.Content); i += 2 {
-		nameNode := requestsNode.Content[i]
-		valNode := requestsNode.Content[i+1]
-
-		name := nameNode.Value
+		name := requestsNode.Content[i].Value
 		if !filter[name] {
 			continue
 		}
 		foundRequests[name] = true
 
-		var req Request
-		if err := valNode.Decode(&req); err != nil {
-			if strings.Contains(err.Error(), "invalid map key") {
-				return fmt.Errorf("%sfailed to decode request %q: %w\n%sHint: Check for unquoted template variables like {{foo}} used as values%s", colorRed, name, err, colorYellow, colorReset)
-			}
-			return fmt.Errorf("%sfailed to decode request %q: %w%s", colorRed, name, err, colorReset)
-		}
-
-		fmt.Printf("\n%s--- %s[%s]%s %s ---%s\n", colorBold, colorCyan, name, colorReset, req.Description, colorReset)
-		if err := r.executeRequest(name, req); err != nil {
+		if err := r.runRequest(name, nil); err != nil {
 			return err
 		}
 	}
@@ -234,104 +485,383 @@ func (r *Runner) ExecuteRequests(reqNames string) error {
 	return nil
 }
 
-func (r *Runner) executeRequest(name string, req Request) error {
-	rawURL := r.substitute(req.URL)
+// runRequest resolves a request by name and executes it with the given
+// template arguments layered over the request's own `args` defaults.
+func (r *Runner) runRequest(name string, args map[string]interface{}) error {
+	req, err := r.resolveRequest(name, nil)
+	if err != nil {
+		return err
+	}
 
-	// Handle query parameters
-	if req.Params != nil {
-		u, err := url.Parse(rawURL)
-		if err != nil {
-			return fmt.Errorf("%sfailed to parse URL %q: %w%s", colorRed, rawURL, err, colorReset)
+	if r.iterations == nil {
+		r.iterations = make(map[string]int)
+	}
+	r.iterations[name]++
+	r.iteration = r.iterations[name]
+
+	record := make(map[string]interface{})
+	if req.Seed != nil {
+		// The run's own sequence continues after this request as if it
+		// had not been seeded
+		defer pinSeed(*req.Seed)()
+		record["seed"] = *req.Seed
+	}
+	r.generated = []interface{}{}
+	r.bound = make(map[string]interface{})
+
+	r.Args = r.substituteMap(deepMerge(req.Args, args))
+	defer func() { r.Args = nil }()
+
+	description := req.Description
+	if req.Seed != nil {
+		description = fmt.Sprintf("%s (seed %d)", description, *req.Seed)
+	}
+	fmt.Printf("\n%s--- %s[%s]%s %s ---%s\n", colorBold, colorCyan, name, colorReset, description, colorReset)
+	err = r.executeRequest(name, req)
+
+	record["generated"] = r.generated
+	r.generated = nil
+	r.bound = nil
+	if run, ok := r.State["$run"].(map[string]interface{}); ok {
+		if requests, ok := run["requests"].(map[string]interface{}); ok {
+			requests[name] = record
+			r.saveState()
 		}
-		q := u.Query()
-		params := r.substituteMap(req.Params)
-		for k, v := range params {
-			q.Set(k, fmt.Sprintf("%v", v))
+	}
+	return err
+}
+
+// resolveRequest decodes the named request and applies its `extends` chain.
+// The chain is tracked to report inheritance cycles.
+func (r *Runner) resolveRequest(name string, chain []string) (Request, error) {
+	for _, seen := range chain {
+		if seen == name {
+			return Request{}, fmt.Errorf("%srequest inheritance cycle: %s -> %s%s", colorRed, strings.Join(chain, " -> "), name, colorReset)
 		}
-		u.RawQuery = q.Encode()
-		rawURL = u.String()
 	}
 
-	methodColor := colorCyan
-	switch req.Method {
-	case "GET":
-		methodColor = colorGreen
-	case "POST":
-		methodColor = colorCyan
-	case "PUT", "PATCH":
-		methodColor = colorYellow
-	case "DELETE":
-		methodColor = colorRed
+	valNode := r.findRequestNode(name)
+	if valNode == nil {
+		if len(chain) > 0 {
+			return Request{}, fmt.Errorf("%srequest %q extends unknown request %q%s", colorRed, chain[len(chain)-1], name, colorReset)
+		}
+		return Request{}, fmt.Errorf("%srequest %q not found%s", colorRed, name, colorReset)
 	}
 
-	fmt.Printf("%s%s%s %s\n", methodColor, req.Method, colorReset, rawURL)
+	var req Request
+	if err := valNode.Decode(&req); err != nil {
+		if strings.Contains(err.Error(), "invalid map key") {
+			return Request{}, fmt.Errorf("%sfailed to decode request %q: %w\n%sHint: Check for unquoted template variables like {{foo}} used as values%s", colorRed, name, err, colorYellow, colorReset)
+		}
+		return Request{}, fmt.Errorf("%sfailed to decode request %q: %w%s", colorRed, name, err, colorReset)
+	}
 
-	var bodyReader io.Reader
+	req.dir = r.Config.requestDirs[name]
+
+	if req.Extends == "" {
+		return req, nil
+	}
+
+	base, err := r.resolveRequest(req.Extends, append(chain, name))
+	if err != nil {
+		return Request{}, err
+	}
+	return mergeRequests(base, req), nil
+}
+
+// findRequestNode returns the YAML node of the named request, or nil.
+func (r *Runner) findRequestNode(name string) *yaml.Node {
+	requestsNode := r.Config.Requests
+	if requestsNode.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(requestsNode.Content); i += 2 {
+		if requestsNode.Content[i].Value == name {
+			return requestsNode.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mergeRequests layers child over base. Scalar fields are replaced when set,
+// while headers, path params, params, json, form, files and args are deep-merged.
+func mergeRequests(base, child Request) Request {
+	merged := base
+	merged.Extends = child.Extends
+	if child.Method != "" {
+		merged.Method = child.Method
+	}
+	if child.URL != "" {
+		merged.URL = child.URL
+	}
+	if child.Description != "" {
+		merged.Description = child.Description
+	}
+	if child.QueryStyle != "" {
+		merged.QueryStyle = child.QueryStyle
+	}
+	if child.Body != "" {
+		merged.Body = child.Body
+	}
+	if child.Seed != nil {
+		merged.Seed = child.Seed
+	}
+	if child.TLSInfo {
+		merged.TLSInfo = true
+	}
+	if child.FollowRedirects != nil {
+		merged.FollowRedirects = child.FollowRedirects
+	}
+	if child.MaxRedirects != nil {
+		merged.MaxRedirects = child.MaxRedirects
+	}
+	if child.Stream != nil {
+		merged.Stream = child.Stream
+	}
+	if child.WebSocket != nil {
+		merged.WebSocket = child.WebSocket
+	}
+	if child.Pre != "" {
+		merged.Pre = child.Pre
+	}
+	if child.Post != "" {
+		merged.Post = child.Post
+	}
+	if child.Auth != nil {
+		merged.Auth = child.Auth
+	}
+	if child.Assert != nil {
+		merged.Assert = child.Assert
+	}
+	if child.Parts != nil {
+		merged.Parts = child.Parts
+		merged.dir = child.dir
+	}
+	merged.TransportConfig = base.TransportConfig.merge(child.TransportConfig)
+	merged.Headers = mergeMaps(base.Headers, child.Headers)
+	merged.PathParams = mergeMaps(base.PathParams, child.PathParams)
+	merged.Files = mergeMaps(base.Files, child.Files)
+	if len(child.Files) > 0 {
+		merged.dir = child.dir
+	}
+	merged.Params = mergeParams(base.Params, child.Params)
+	merged.JSON = deepMerge(base.JSON, child.JSON)
+	merged.Form = deepMerge(base.Form, child.Form)
+	merged.Args = deepMerge(base.Args, child.Args)
+	return merged
+}
+
+func mergeMaps[V any](base, override map[string]V) map[string]V {
+	if base == nil && override == nil {
+		return nil
+	}
+	res := make(map[string]V)
+	for k, v := range base {
+		res[k] = v
+	}
+	for k, v := range override {
+		res[k] = v
+	}
+	return res
+}
+
+// deepMerge returns a new map with override recursively layered over base.
+// Nested maps are merged key by key, any other value is replaced.
+func deepMerge(base, override map[string]interface{}) map[string]interface{} {
+	if base == nil && override == nil {
+		return nil
+	}
+	res := make(map[string]interface{})
+	for k, v := range base {
+		res[k] = v
+	}
+	for k, v := range override {
+		baseMap, baseIsMap := res[k].(map[string]interface{})
+		overrideMap, overrideIsMap := v.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			res[k] = deepMerge(baseMap, overrideMap)
+		} else {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+func (r *Runner) executeRequest(name string, req Request) error {
+	rawURL := r.substituteURL(req.URL, req.PathParams)
+
+	// Handle query parameters
+	if req.Params != nil {
+		var err error
+		if rawURL, err = r.applyParams(rawURL, req.Params, req.QueryStyle); err != nil {
+			return err
+		}
+	}
+
+	var body []byte
+	var stream io.Reader
+	var streamLength int64
 	var contentType string
 
 	if req.JSON != nil {
 		jsonBody := r.substituteMap(req.JSON)
-		data, _ := json.Marshal(jsonBody)
-		bodyReader = bytes.NewReader(data)
+		body, _ = json.Marshal(jsonBody)
 		contentType = "application/json"
-	} else if req.Files != nil {
-		body := &bytes.Buffer{}
-		writer := multipart.NewWriter(body)
-
-		// Add form fields
+	} else if req.Body != "" {
+		contentType = rawBodyContentType(req.Headers, req.Body)
+		body = []byte(r.substituteEscaped(req.Body, bodyEscaper(contentType)))
+	} else if req.Files != nil || req.Parts != nil {
+		var form map[string]interface{}
 		if req.Form != nil {
-			form := r.substituteMap(req.Form)
-			for k, v := range form {
-				_ = writer.WriteField(k, fmt.Sprintf("%v", v))
-			}
+			form = r.substituteMap(req.Form)
 		}
 
-		// Add files
-		for field, path := range req.Files {
-			substitutedPath := r.substitute(path)
-			file, err := os.Open(substitutedPath)
-			if err != nil {
-				return fmt.Errorf("%sfailed to open file %q: %w%s", colorRed, substitutedPath, err, colorReset)
+		var uploads []*upload
+		for _, field := range sortedKeys(req.Files) {
+			for _, spec := range req.Files[field] {
+				u, err := r.openUpload(field, spec, req.dir)
+				if err != nil {
+					closeUploads(uploads)
+					return err
+				}
+				uploads = append(uploads, u)
 			}
-			defer file.Close()
-
-			part, err := writer.CreateFormFile(field, substitutedPath)
+		}
+		for _, part := range req.Parts {
+			u, err := r.openPart(part, req.dir)
 			if err != nil {
-				return fmt.Errorf("%sfailed to create form file for %q: %w%s", colorRed, field, err, colorReset)
+				closeUploads(uploads)
+				return err
 			}
-			_, _ = io.Copy(part, file)
+			uploads = append(uploads, u)
 		}
 
-		writer.Close()
-		bodyReader = body
+		// Stream the body so that large files are never held in memory. Its
+		// length is known up front unless a file's size cannot be determined.
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		writer := multipart.NewWriter(pw)
+		// Derive the boundary from the seeded generator so bodies are reproducible
+		_ = writer.SetBoundary(fmt.Sprintf("hepi%016x%016x", rng.Uint64(), rng.Uint64()))
+		streamLength = multipartLength(writer.Boundary(), form, uploads)
+		go func() { pw.CloseWithError(writeMultipart(writer, form, uploads)) }()
+		stream = pr
 		contentType = writer.FormDataContentType()
+
+		// Script hooks and signers work on the complete body
+		if req.Pre != "" || req.Auth != nil {
+			data, err := io.ReadAll(pr)
+			if err != nil {
+				return fmt.Errorf("%sfailed to build multipart body: %w%s", colorRed, err, colorReset)
+			}
+			body, stream = data, nil
+		}
 	} else if req.Form != nil {
 		formData := url.Values{}
 		form := r.substituteMap(req.Form)
-		for k, v := range form {
-			formData.Set(k, fmt.Sprintf("%v", v))
+		for _, k := range sortedKeys(form) {
+			for _, v := range formValues(form[k]) {
+				formData.Add(k, v)
+			}
 		}
-		bodyReader = strings.NewReader(formData.Encode())
+		body = []byte(formData.Encode())
 		contentType = "application/x-www-form-urlencoded"
 	}
 
-	httpReq, err := http.NewRequest(req.Method, rawURL, bodyReader)
+	headers := make(map[string]string)
+	if contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+	for _, k := range sortedKeys(req.Headers) {
+		headers[http.CanonicalHeaderKey(k)] = r.substitute(req.Headers[k])
+	}
+
+	method := req.Method
+	if method == "" && req.WebSocket != nil {
+		// The WebSocket handshake is a GET request
+		method = "GET"
+	}
+	outgoing := &scriptRequest{Method: method, URL: rawURL, Headers: headers, Body: body}
+	if req.Pre != "" {
+		if err := r.runScript("pre", req.Pre, outgoing, nil); err != nil {
+			return err
+		}
+	}
+	if req.Auth != nil {
+		if err := r.signRequest(req.Auth, outgoing); err != nil {
+			return err
+		}
+	}
+	if req.WebSocket != nil {
+		return r.executeWebSocket(name, req, outgoing)
+	}
+	method, rawURL, headers, body = outgoing.Method, outgoing.URL, outgoing.Headers, outgoing.Body
+
+	methodColor := colorCyan
+	switch method {
+	case "GET":
+		methodColor = colorGreen
+	case "POST":
+		methodColor = colorCyan
+	case "PUT", "PATCH":
+		methodColor = colorYellow
+	case "DELETE":
+		methodColor = colorRed
+	}
+
+	fmt.Printf("%s%s%s %s\n", methodColor, method, colorReset, rawURL)
+
+	var bodyReader io.Reader
+	if stream != nil {
+		bodyReader = stream
+	} else if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	httpReq, err := http.NewRequest(method, rawURL, bodyReader)
 	if err != nil {
 		return fmt.Errorf("%sfailed to create HTTP request: %w%s", colorRed, err, colorReset)
 	}
+	if stream != nil && streamLength >= 0 {
+		httpReq.ContentLength = streamLength
+	}
 
-	if contentType != "" {
-		httpReq.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
 	}
 
-	for k, v := range req.Headers {
-		httpReq.Header.Set(k, r.substitute(v))
+	client, err := r.clientFor(req)
+	if err != nil {
+		return err
+	}
+
+	var hops []redirectHop
+	client.CheckRedirect = r.checkRedirect(req, &hops)
+
+	timing := &requestTiming{}
+	httpReq = httpReq.WithContext(httptrace.WithClientTrace(httpReq.Context(), timing.trace()))
+
+	var headerTimer *time.Timer
+	if req.Stream != nil {
+		// Streams may run indefinitely, so the timeout only covers the response headers
+		ctx, cancel := context.WithCancelCause(httpReq.Context())
+		defer cancel(nil)
+		if client.Timeout > 0 {
+			headerTimer = time.AfterFunc(client.Timeout, func() { cancel(errStreamTimeout) })
+		}
+		httpReq = httpReq.WithContext(ctx)
+		client.Timeout = 0
 	}
 
 	startTime := time.Now()
-	resp, err := r.HTTPClient.Do(httpReq)
+	timing.start = startTime
+	resp, err := client.Do(httpReq)
+	if headerTimer != nil {
+		headerTimer.Stop()
+	}
 	if err != nil {
-		if os.IsTimeout(err) {
+		printRedirects(hops)
+		if os.IsTimeout(err) || context.Cause(httpReq.Context()) == errStreamTimeout {
 			return fmt.Errorf("%srequest timed out after %v%s", colorRed, r.HTTPClient.Timeout, colorReset)
 		}
 		return fmt.Errorf("%srequest failed: %w%s", colorRed, err, colorReset)
@@ -346,7 +876,12 @@ func (r *Runner) executeRequest(name string, req Request) error {
 		statusColor = colorYellow
 	}
 
-	fmt.Printf("Status: %s%s%s (took %s%v%s)\n", statusColor, resp.Status, colorReset, colorYellow, duration.Round(time.Millisecond), colorReset)
+	printRedirects(hops)
+	fmt.Printf("Status: %s%s%s (%s, took %s%v%s)\n", statusColor, resp.Status, colorReset, resp.Proto, colorYellow, duration.Round(time.Millisecond), colorReset)
+
+	if (r.ShowTLS || req.TLSInfo) && resp.TLS != nil {
+		printTLSInfo(resp.TLS)
+	}
 
 	if r.ShowHeaders {
 		fmt.Printf("\n%sHeaders:%s\n", colorBold, colorReset)
@@ -355,17 +890,63 @@ func (r *Runner) executeRequest(name string, req Request) error {
 		}
 	}
 
-	respData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("%sfailed to read response body: %w%s", colorRed, err, colorReset)
+	var respData []byte
+	if req.Stream != nil {
+		events, err := r.readStream(resp.Body, req.Stream)
+		if err != nil {
+			return err
+		}
+		respData, _ = json.Marshal(events)
+	} else {
+		respData, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("%sfailed to read response body: %w%s", colorRed, err, colorReset)
+		}
+	}
+	timing.done = time.Now()
+
+	if r.ShowTiming {
+		timing.print()
+	}
+
+	var result interface{}
+	isJSON := json.Unmarshal(respData, &result) == nil
+
+	r.recordRequest(name, outgoing, resp, duration, timing, hops)
+
+	received := &scriptResponse{
+		Status:  resp.StatusCode,
+		Headers: make(map[string]string),
+		Body:    respData,
+		JSON:    result,
+	}
+	for k, v := range resp.Header {
+		received.Headers[k] = strings.Join(v, ", ")
 	}
 
-	if len(respData) > 0 {
-		var result interface{}
-		if err := json.Unmarshal(respData, &result); err == nil {
+	if req.Post != "" {
+		if err := r.runScript("post", req.Post, nil, received); err != nil {
+			return err
+		}
+		if !bytes.Equal(received.Body, respData) {
+			respData = received.Body
+			result = nil
+			isJSON = json.Unmarshal(respData, &result) == nil
+		} else if received.JSON != nil {
+			result = received.JSON
+			isJSON = true
+		}
+	}
+
+	if len(respData) > 0 || isJSON {
+		if isJSON {
 			result = decodeRecursive(result)
 			r.State[name] = result
 			r.saveState()
+			if req.Stream != nil {
+				// The events were printed as they arrived
+				return r.runAssertions(req.Assert, received)
+			}
 			fmt.Printf("\n%sResponse:%s\n", colorBold, colorReset)
 
 			var enc *jsoncolor.Encoder
@@ -387,69 +968,258 @@ func (r *Runner) executeRequest(name string, req Request) error {
 		}
 	}
 
-	return nil
+	return r.runAssertions(req.Assert, received)
 }
 
 func (r *Runner) substitute(s string) string {
-	// 1. Handle [[dynamic]] placeholders using the Generators map and oneof support
-	genRegex := regexp.MustCompile(`\[\[(.*?)\]\]`)
-	s = genRegex.ReplaceAllStringFunc(s, func(match string) string {
-		tag := strings.Trim(match[2:len(match)-2], " ")
-
-		// Handle [[oneof: a, b, c]]
-		if strings.HasPrefix(tag, "oneof:") {
-			parts := strings.Split(tag[6:], ",")
-			if len(parts) > 0 {
-				return strings.TrimSpace(parts[rand.Intn(len(parts))])
-			}
-		}
+	return r.substituteEscaped(s, nil)
+}
 
-		// Handle Generators map
-		if gen, ok := Generators[tag]; ok {
-			return gen()
+// substituteWith substitutes s with the variable name bound to value, e.g.
+// the current event as {{event}}. A variable of the same name is restored
+// afterwards.
+func (r *Runner) substituteWith(s, name string, value interface{}) string {
+	prev, had := r.Vars[name]
+	r.Vars[name] = value
+	defer func() {
+		if had {
+			r.Vars[name] = prev
+		} else {
+			delete(r.Vars, name)
 		}
+	}()
+	return r.substitute(s)
+}
 
-		// Fallback for random_ prefix if not already present
-		if gen, ok := Generators["random_"+tag]; ok {
-			return gen()
+// substituteEscaped substitutes placeholders like substitute, but passes each
+// inserted value through escape together with the text preceding it, so the
+// value can be escaped for where it lands. Values piped through `| raw` are
+// inserted verbatim, as are all values when escape is nil.
+func (r *Runner) substituteEscaped(s string, escape func(prefix, value string) string) string {
+	// 1. Handle [[dynamic]] placeholders using the generators of this run
+	s = replaceGeneratorTags(s, func(prefix, match, tag string) string {
+		tag, bind := splitBinding(tag)
+		name, args := parseGeneratorTag(tag)
+
+		gen, ok := r.generators[name]
+		if !ok {
+			// Fallback for random_ prefix if not already present
+			gen, ok = r.generators["random_"+name]
+		}
+		if !ok {
+			return match
 		}
 
-		return match
+		val, err := gen(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sWarning: %s: %v%s\n", colorYellow, match, err, colorReset)
+			return match
+		}
+		if r.generated != nil {
+			r.generated = append(r.generated, map[string]interface{}{"tag": match, "value": val})
+		}
+		if bind != "" {
+			// Bound values are reusable as {{name}} for the rest of the run
+			r.Vars[bind] = val
+			if r.bound != nil {
+				r.bound[bind] = val
+			}
+		}
+		if escape != nil {
+			return escape(prefix, val)
+		}
+		return val
 	})
 
 	// 2. Handle {{variables}}
-	re := regexp.MustCompile(`{{(.*?)}}`)
-	return re.ReplaceAllStringFunc(s, func(match string) string {
-		key := strings.Trim(match[2:len(match)-2], " ")
+	var b strings.Builder
+	last := 0
+	for _, loc := range variablePattern.FindAllStringIndex(s, -1) {
+		b.WriteString(s[last:loc[0]])
+		last = loc[1]
+
+		match := s[loc[0]:loc[1]]
+		val, raw, ok := r.resolvePlaceholder(match)
+		if !ok {
+			b.WriteString(match)
+			continue
+		}
+		if escape != nil && !raw {
+			val = escape(b.String(), val)
+		}
+		b.WriteString(val)
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+var variablePattern = regexp.MustCompile(`{{(.*?)}}`)
+
+// resolvePlaceholder resolves a `{{key | filters}}` placeholder. It reports
+// whether the value opted out of escaping with `| raw`.
+func (r *Runner) resolvePlaceholder(match string) (string, bool, bool) {
+	key := strings.Trim(match[2:len(match)-2], " ")
+
+	// Command output
+	if strings.HasPrefix(key, "$exec:") {
+		out, ok := r.resolveExec(key[len("$exec:"):])
+		return out, false, ok
+	}
+
+	name, filters := splitFilters(key)
+	raw := false
+	for i := 0; i < len(filters); i++ {
+		if filters[i] == "raw" {
+			raw = true
+			filters = append(filters[:i:i], filters[i+1:]...)
+			i--
+		}
+	}
+
+	val, ok := r.lookupVariable(name)
+	if !ok {
+		return "", false, false
+	}
+	out, err := applyFilters(val, filters)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sWarning: %s: %v%s\n", colorYellow, match, err, colorReset)
+		return "", false, false
+	}
+	return out, raw, true
+}
+
+// lookupVariable resolves a {{variable}} name without its filters.
+func (r *Runner) lookupVariable(key string) (interface{}, bool) {
+	// Reserved dynamic variables such as $now and $counter:name
+	if strings.HasPrefix(key, "$") {
+		if val, ok := r.resolveDynamic(key); ok {
+			return val, true
+		}
+	}
+
+	// Template arguments of the current request
+	if strings.HasPrefix(key, "args.") && r.Args != nil {
+		return getValueFromMap(r.Args, strings.Split(key, ".")[1:]), true
+	}
+
+	// Run-scoped variables
+	if val, ok := r.Vars[key]; ok {
+		return val, true
+	}
+	if name, path, ok := strings.Cut(key, "."); ok {
+		if val, ok := r.Vars[name].(map[string]interface{}); ok {
+			return getValueFromMap(val, strings.Split(path, ".")), true
+		}
+	}
+
+	// Priority 1: System Environment Variables
+	if val, exists := os.LookupEnv(key); exists {
+		return val, true
+	}
+
+	// Priority 2: Config Environment Variables
+	if val, ok := r.Environment[key]; ok {
+		return val, true
+	}
 
-		// Priority 1: System Environment Variables
-		if val, exists := os.LookupEnv(key); exists {
-			return val
+	// Priority 3: Previous Request Results
+	parts := strings.Split(key, ".")
+	if len(parts) > 1 {
+		if val, ok := r.lookupState(parts); ok {
+			return val, true
 		}
+	}
+
+	return nil, false
+}
 
-		// Priority 2: Config Environment Variables
-		if val, ok := r.Environment[key]; ok {
-			return fmt.Sprintf("%v", val)
+// lookupState resolves `name.path` against the stored response body of a
+// request. The `request`, `response` and `vars` sections of the request
+// record are used unless the response body has a field of the same name.
+func (r *Runner) lookupState(parts []string) (string, bool) {
+	res, hasBody := r.State[parts[0]]
+	if body, ok := res.(map[string]interface{}); ok {
+		if _, ok := body[parts[1]]; ok {
+			return getValueFromMap(res, parts[1:]), true
 		}
+	}
 
-		// Priority 3: Previous Request Results
-		parts := strings.Split(key, ".")
-		if len(parts) > 1 {
-			if res, ok := r.State[parts[0]]; ok {
-				return getValueFromMap(res, parts[1:])
+	if requests, ok := r.State["$requests"].(map[string]interface{}); ok {
+		if record, ok := requests[parts[0]].(map[string]interface{}); ok {
+			if _, ok := record[parts[1]]; ok {
+				return getValueFromMap(record, parts[1:]), true
 			}
 		}
+	}
 
-		return match
-	})
+	if hasBody {
+		return getValueFromMap(res, parts[1:]), true
+	}
+	return "", false
 }
 
+// replaceGeneratorTags calls fn for every `[[tag]]` in s, along with the
+// output preceding it, and replaces the placeholder with its result. Unlike a
+// plain regular expression, a `]]` inside a quoted argument does not end the tag.
+func replaceGeneratorTags(s string, fn func(prefix, match, tag string) string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "[[")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+
+		// Quotes only delimit an argument when they start it, so the
+		// apostrophe in `[[oneof: don't, do]]` is plain text
+		end := -1
+		var quote byte
+		argStart := false
+		for i := start + 2; i < len(s); i++ {
+			c := s[i]
+			if quote != 0 {
+				if c == '\\' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == ']' && i+1 < len(s) && s[i+1] == ']' {
+				end = i
+				break
+			}
+			switch {
+			case argStart && (c == '"' || c == '\''):
+				quote = c
+				argStart = false
+			case c == ':' || c == ',':
+				argStart = true
+			case c != ' ' && c != '\t':
+				argStart = false
+			}
+		}
+		if end < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+
+		match := s[start : end+2]
+		b.WriteString(s[:start])
+		b.WriteString(fn(b.String(), match, strings.TrimSpace(s[start+2:end])))
+		s = s[end+2:]
+	}
+}
+
+// substituteMap substitutes all string values of m. Keys are visited in
+// sorted order so that seeded generators produce the same values every run.
 func (r *Runner) substituteMap(m map[string]interface{}) map[string]interface{} {
 	res := make(map[string]interface{})
-	for k, v := range m {
+	for _, k := range sortedKeys(m) {
+		v := m[k]
 		switch val := v.(type) {
 		case string:
-			res[k] = r.substitute(val)
+			res[k] = r.substituteValue(val)
 		case map[string]interface{}:
 			res[k] = r.substituteMap(val)
 		case []interface{}:
@@ -461,12 +1231,31 @@ func (r *Runner) substituteMap(m map[string]interface{}) map[string]interface{}
 	return res
 }
 
+// substituteStringMap substitutes all values of a string map.
+func (r *Runner) substituteStringMap(m map[string]string) map[string]string {
+	res := make(map[string]string, len(m))
+	for _, k := range sortedKeys(m) {
+		res[k] = r.substitute(m[k])
+	}
+	return res
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (r *Runner) substituteSlice(s []interface{}) []interface{} {
 	res := make([]interface{}, len(s))
 	for i, v := range s {
 		switch val := v.(type) {
 		case string:
-			res[i] = r.substitute(val)
+			res[i] = r.substituteValue(val)
 		case map[string]interface{}:
 			res[i] = r.substituteMap(val)
 		case []interface{}:
@@ -510,11 +1299,22 @@ func (r *Runner) PrintHelp() {
 	}
 
 	fmt.Println("\nAvailable Groups:")
-	for name, reqs := range r.Config.Groups {
+	for name, group := range r.Config.Groups {
+		reqs := make([]string, len(group.Steps))
+		for i, step := range group.Steps {
+			reqs[i] = step.String()
+		}
 		fmt.Printf("  - %s (%s)\n", name, strings.Join(reqs, ", "))
 	}
 
-	fmt.Printf("\nUsage:\n  %s -env <environment> -file <file_path> -req <request1,request2,...> -group <group_name> -headers\n", os.Args[0])
+	if names := r.pluginNames(); len(names) > 0 {
+		fmt.Println("\nPlugins:")
+		for _, name := range names {
+			fmt.Printf("  - %s\n", name)
+		}
+	}
+
+	fmt.Printf("\nUsage:\n  %s -env <environment> -file <file_or_dir> [-file ...] -req <request1,request2,...> -group <group_name> -headers\n", os.Args[0])
 }
 
 func loadState(envName, stateFile string) map[string]interface{} {
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRunner returns a runner with an empty environment and state, which
+// it saves to a temporary directory.
+func newTestRunner(t *testing.T) *Runner {
+	t.Helper()
+	return &Runner{
+		EnvName:     "test",
+		Environment: map[string]interface{}{},
+		State:       map[string]interface{}{},
+		Vars:        map[string]interface{}{},
+		HTTPClient:  &http.Client{Timeout: 5 * time.Second},
+		StateFile:   filepath.Join(t.TempDir(), "state.json"),
+		generators:  builtinGenerators(""),
+	}
+}
+
+// newConfigRunner writes config to a file and loads it into a runner for the
+// `test` environment. `{{host}}` in config is replaced with the address of a
+// server answering every request with its body.
+func newConfigRunner(t *testing.T, config string) *Runner {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := io.ReadAll(r.Body)
+		if len(body) == 0 {
+			body = []byte("{}")
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hepi.yaml")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(config, "{{host}}", srv.URL)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRunner([]string{path}, "test", filepath.Join(dir, "state.json"), 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+// generated returns the values generated by the last run of a request.
+func generated(r *Runner, name string) []interface{} {
+	requests := r.State["$run"].(map[string]interface{})["requests"].(map[string]interface{})
+	var values []interface{}
+	for _, g := range requests[name].(map[string]interface{})["generated"].([]interface{}) {
+		values = append(values, g.(map[string]interface{})["value"])
+	}
+	return values
+}
+
+func TestResolveRequestExtends(t *testing.T) {
+	r := newConfigRunner(t, `
+environments:
+  test: {}
+requests:
+  base:
+    method: POST
+    url: "{{host}}/users"
+    headers: {Accept: application/json, X-Team: core}
+    json: {user: {name: a, role: guest}, active: true}
+  admin:
+    extends: base
+    headers: {X-Team: admin}
+    json: {user: {role: admin}}
+  audited_admin:
+    extends: admin
+    method: PUT
+    description: audited
+`)
+	req, err := r.resolveRequest("audited_admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "PUT" || req.Description != "audited" || !strings.HasSuffix(req.URL, "/users") {
+		t.Errorf("scalars = %s %s %q, want PUT, the base URL and audited", req.Method, req.URL, req.Description)
+	}
+	wantHeaders := map[string]string{"Accept": "application/json", "X-Team": "admin"}
+	if !reflect.DeepEqual(req.Headers, wantHeaders) {
+		t.Errorf("headers = %v, want %v", req.Headers, wantHeaders)
+	}
+	wantJSON := map[string]interface{}{
+		"user":   map[string]interface{}{"name": "a", "role": "admin"},
+		"active": true,
+	}
+	if !reflect.DeepEqual(req.JSON, wantJSON) {
+		t.Errorf("json = %v, want %v", req.JSON, wantJSON)
+	}
+}
+
+func TestResolveRequestErrors(t *testing.T) {
+	r := newConfigRunner(t, `
+environments:
+  test: {}
+requests:
+  a: {extends: b, url: "{{host}}/"}
+  b: {extends: c}
+  c: {extends: a}
+  self: {extends: self}
+  orphan: {extends: missing}
+`)
+	tests := []struct {
+		name, want string
+	}{
+		{"a", "request inheritance cycle: a -> b -> c -> a"},
+		{"self", "request inheritance cycle: self -> self"},
+		{"orphan", `request "orphan" extends unknown request "missing"`},
+		{"missing", `request "missing" not found`},
+	}
+	for _, tt := range tests {
+		_, err := r.resolveRequest(tt.name, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("resolveRequest(%s) error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestGroupStepArgs(t *testing.T) {
+	r := newConfigRunner(t, `
+environments:
+  test: {}
+requests:
+  create_user:
+    method: POST
+    url: "{{host}}/users"
+    args: {role: guest, team: core}
+    json: {role: "{{args.role}}", team: "{{args.team}}"}
+groups:
+  admins:
+    - create_user: {role: admin}
+`)
+	if err := r.ExecuteGroup("admins"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"role": "admin", "team": "core"}
+	if !reflect.DeepEqual(r.State["create_user"], want) {
+		t.Errorf("group step sent %v, want %v", r.State["create_user"], want)
+	}
+
+	if err := r.runRequest("create_user", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.State["create_user"].(map[string]interface{})["role"]; got != "guest" {
+		t.Errorf("role without step args = %v, want the default guest", got)
+	}
+}
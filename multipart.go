@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileSpec describes an uploaded file. A plain string is a path, which may
+// also be a generated file such as `[[file: 5MB, application/pdf]]` or
+// `[[image: png, 800x600]]`. The mapping form takes a `path`, inline
+// `content` or a `json` document, plus an optional `filename`,
+// `content_type` and extra part `headers`.
+type FileSpec struct {
+	Path        string            `yaml:"path"`
+	Content     *string           `yaml:"content"`
+	JSON        interface{}       `yaml:"json"`
+	Filename    string            `yaml:"filename"`
+	ContentType string            `yaml:"content_type"`
+	Headers     map[string]string `yaml:"headers"`
+}
+
+func (f *FileSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		f.Path = value.Value
+		return nil
+	}
+	type plain FileSpec
+	return value.Decode((*plain)(f))
+}
+
+// FileList holds the files uploaded under one field. It accepts a single
+// file or a list, e.g. `attachments: [a.pdf, b.pdf]`.
+type FileList []FileSpec
+
+func (l *FileList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode((*[]FileSpec)(l))
+	}
+	var spec FileSpec
+	if err := value.Decode(&spec); err != nil {
+		return err
+	}
+	*l = FileList{spec}
+	return nil
+}
+
+// Part is an entry of a request's ordered `parts` list. A part with a `value`
+// is a plain form field; otherwise it takes the same keys as a FileSpec.
+//
+//	parts:
+//	  - {name: metadata, json: {title: Report}}
+//	  - {name: tags[], value: finance}
+//	  - {name: tags[], value: q3}
+//	  - {name: file, path: report.pdf, headers: {X-Checksum: abc}}
+type Part struct {
+	Name  string
+	Value *string
+	File  FileSpec
+}
+
+func (p *Part) UnmarshalYAML(value *yaml.Node) error {
+	var head struct {
+		Name  string  `yaml:"name"`
+		Value *string `yaml:"value"`
+	}
+	if err := value.Decode(&head); err != nil {
+		return err
+	}
+	if head.Name == "" {
+		return fmt.Errorf("line %d: part needs a name", value.Line)
+	}
+	p.Name, p.Value = head.Name, head.Value
+	return value.Decode(&p.File)
+}
+
+// upload is a part ready to be written into a multipart body. Parts without
+// a filename are written as plain fields.
+type upload struct {
+	field       string
+	filename    string
+	contentType string
+	headers     map[string]string
+	content     io.Reader
+	closer      io.Closer
+	// size is the length of content, or -1 if it is not known in advance.
+	size int64
+}
+
+// openPart resolves a `parts` entry.
+func (r *Runner) openPart(part Part, dir string) (*upload, error) {
+	if part.Value == nil {
+		return r.openUpload(r.substitute(part.Name), part.File, dir)
+	}
+	value := r.substitute(*part.Value)
+	u := &upload{
+		field:       r.substitute(part.Name),
+		filename:    r.substitute(part.File.Filename),
+		contentType: r.substitute(part.File.ContentType),
+		content:     strings.NewReader(value),
+		size:        int64(len(value)),
+	}
+	if part.File.Headers != nil {
+		u.headers = r.substituteStringMap(part.File.Headers)
+	}
+	return u, nil
+}
+
+// openUpload resolves a file spec into its content. Relative paths are
+// resolved against dir. Files are named after their base name and typed by
+// their extension unless `filename` and `content_type` say otherwise.
+func (r *Runner) openUpload(field string, spec FileSpec, dir string) (*upload, error) {
+	u := &upload{field: field, filename: r.substitute(spec.Filename), contentType: r.substitute(spec.ContentType)}
+	if spec.Headers != nil {
+		u.headers = r.substituteStringMap(spec.Headers)
+	}
+
+	switch {
+	case spec.JSON != nil:
+		data, err := json.Marshal(r.substituteSlice([]interface{}{spec.JSON})[0])
+		if err != nil {
+			return nil, fmt.Errorf("%sfield %q: %v%s", colorRed, field, err, colorReset)
+		}
+		u.content, u.size = bytes.NewReader(data), int64(len(data))
+		if u.contentType == "" {
+			u.contentType = "application/json"
+		}
+		return u, nil
+	case spec.Content != nil:
+		content := r.substitute(*spec.Content)
+		u.content, u.size = strings.NewReader(content), int64(len(content))
+		if u.filename == "" {
+			u.filename = field
+		}
+	case isGeneratedFile(spec.Path):
+		name, args := parseGeneratorTag(strings.TrimSpace(spec.Path)[2 : len(strings.TrimSpace(spec.Path))-2])
+		var err error
+		var filename, contentType string
+		if name == "image" {
+			u.content, u.size, filename, contentType, err = generateImage(args)
+		} else {
+			u.content, u.size, filename, contentType, err = generateFile(args)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%sfield %q: %s: %v%s", colorRed, field, spec.Path, err, colorReset)
+		}
+		if u.filename == "" {
+			u.filename = filename
+		}
+		if u.contentType == "" {
+			u.contentType = contentType
+		}
+	default:
+		path := r.substitute(spec.Path)
+		if !filepath.IsAbs(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("%sfailed to open file %q: %w%s", colorRed, path, err, colorReset)
+		}
+		u.content, u.closer, u.size = file, file, -1
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+			u.size = info.Size()
+		}
+		if u.filename == "" {
+			u.filename = filepath.Base(path)
+		}
+		if u.contentType == "" {
+			u.contentType = mime.TypeByExtension(filepath.Ext(path))
+		}
+	}
+
+	if u.contentType == "" {
+		u.contentType = "application/octet-stream"
+	}
+	return u, nil
+}
+
+// isGeneratedFile reports whether a file path is a `[[file: ...]]` or
+// `[[image: ...]]` tag.
+func isGeneratedFile(path string) bool {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "[[") || !strings.HasSuffix(path, "]]") {
+		return false
+	}
+	name, _ := parseGeneratorTag(path[2 : len(path)-2])
+	return name == "file" || name == "image"
+}
+
+// writeMultipart writes the form fields followed by the uploads, in order,
+// and closes the writer. List values in the form become repeated fields.
+func writeMultipart(w *multipart.Writer, form map[string]interface{}, uploads []*upload) error {
+	for _, k := range sortedKeys(form) {
+		for _, v := range formValues(form[k]) {
+			if err := w.WriteField(k, v); err != nil {
+				closeUploads(uploads)
+				return err
+			}
+		}
+	}
+	for i, u := range uploads {
+		if err := writeUpload(w, u); err != nil {
+			closeUploads(uploads[i+1:])
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// closeUploads releases the files of uploads that were not written.
+func closeUploads(uploads []*upload) {
+	for _, u := range uploads {
+		if u.closer != nil {
+			u.closer.Close()
+		}
+	}
+}
+
+// formValues returns the values of a form field, one per list element.
+func formValues(v interface{}) []string {
+	if list, ok := v.([]interface{}); ok {
+		values := make([]string, len(list))
+		for i, item := range list {
+			values[i] = fmt.Sprintf("%v", item)
+		}
+		return values
+	}
+	return []string{fmt.Sprintf("%v", v)}
+}
+
+// multipartLength returns the length of the body writeMultipart writes with
+// the given boundary, or -1 if the size of an upload is not known. It lets
+// uploads be sent with a Content-Length instead of chunked.
+func multipartLength(boundary string, form map[string]interface{}, uploads []*upload) int64 {
+	var counter byteCounter
+	w := multipart.NewWriter(&counter)
+	_ = w.SetBoundary(boundary)
+	for _, k := range sortedKeys(form) {
+		for _, v := range formValues(form[k]) {
+			_ = w.WriteField(k, v)
+		}
+	}
+	size := int64(0)
+	for _, u := range uploads {
+		if u.size < 0 {
+			return -1
+		}
+		_, _ = createPart(w, u)
+		size += u.size
+	}
+	_ = w.Close()
+	return int64(counter) + size
+}
+
+// byteCounter is a writer that only counts what is written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// writeUpload writes a part with its headers and content.
+func writeUpload(w *multipart.Writer, u *upload) error {
+	if u.closer != nil {
+		defer u.closer.Close()
+	}
+	part, err := createPart(w, u)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, u.content)
+	return err
+}
+
+// createPart starts a part with its Content-Disposition, Content-Type and
+// extra headers.
+func createPart(w *multipart.Writer, u *upload) (io.Writer, error) {
+	h := make(textproto.MIMEHeader)
+	disposition := fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(u.field))
+	if u.filename != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, escapeQuotes(u.filename))
+	}
+	h.Set("Content-Disposition", disposition)
+	if u.contentType != "" {
+		h.Set("Content-Type", u.contentType)
+	}
+	for _, k := range sortedKeys(u.headers) {
+		h.Set(k, u.headers[k])
+	}
+	return w.CreatePart(h)
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// fileSignatures are the leading bytes that let servers sniff the type of a
+// generated file.
+var fileSignatures = map[string]string{
+	"application/pdf":  "%PDF-1.7\n%\xe2\xe3\xcf\xd3\n",
+	"application/zip":  "PK\x03\x04",
+	"application/gzip": "\x1f\x8b\x08",
+	"image/png":        "\x89PNG\r\n\x1a\n",
+	"image/jpeg":       "\xff\xd8\xff\xe0",
+	"image/gif":        "GIF89a",
+}
+
+// generateFile returns a reader producing a file of the requested size, e.g.
+// `[[file: 5MB, application/pdf]]`. The content starts with the type's file
+// signature and is filled with seeded random bytes, or printable text for
+// text types. It is produced while streaming and never held in memory.
+func generateFile(args []string) (io.Reader, int64, string, string, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, 0, "", "", fmt.Errorf("expected size and optional content type")
+	}
+	size, err := parseSize(args[0])
+	if err != nil {
+		return nil, 0, "", "", err
+	}
+	contentType := "application/octet-stream"
+	if len(args) == 2 {
+		contentType = args[1]
+	}
+
+	prefix := []byte(fileSignatures[contentType])
+	if int64(len(prefix)) > size {
+		prefix = prefix[:size]
+	}
+	src := rand.New(rand.NewSource(rng.Int63()))
+	var fill io.Reader = src
+	if strings.HasPrefix(contentType, "text/") {
+		fill = textReader{src}
+	}
+	content := io.MultiReader(bytes.NewReader(prefix), io.LimitReader(fill, size-int64(len(prefix))))
+
+	return content, size, "file" + extensionFor(contentType), contentType, nil
+}
+
+// textReader produces random printable lines.
+type textReader struct {
+	src *rand.Rand
+}
+
+func (t textReader) Read(p []byte) (int, error) {
+	const charset = "abcdefghijklmnopqrstuvwxyz      "
+	for i := range p {
+		if t.src.Intn(64) == 0 {
+			p[i] = '\n'
+		} else {
+			p[i] = charset[t.src.Intn(len(charset))]
+		}
+	}
+	return len(p), nil
+}
+
+// maxImagePixels caps the size of generated images, which are encoded in
+// memory.
+const maxImagePixels = 4096 * 4096
+
+// generateImage returns a valid image, e.g. `[[image: png, 800x600]]`.
+// Formats are png, jpeg and gif; the default is a 100x100 png.
+func generateImage(args []string) (io.Reader, int64, string, string, error) {
+	if len(args) > 2 {
+		return nil, 0, "", "", fmt.Errorf("expected format and optional WIDTHxHEIGHT")
+	}
+	format := "png"
+	if len(args) > 0 {
+		format = strings.ToLower(args[0])
+	}
+	width, height := 100, 100
+	if len(args) == 2 {
+		w, h, ok := strings.Cut(strings.ToLower(args[1]), "x")
+		var errW, errH error
+		width, errW = strconv.Atoi(w)
+		height, errH = strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return nil, 0, "", "", fmt.Errorf("invalid dimensions %q", args[1])
+		}
+		if width > maxImagePixels/height {
+			return nil, 0, "", "", fmt.Errorf("image of %dx%d is larger than %d pixels", width, height, maxImagePixels)
+		}
+	}
+
+	// A gradient between two seeded colors
+	from := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+	to := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := float64(x+y) / float64(width+height)
+			img.Set(x, y, color.RGBA{
+				uint8(float64(from.R) + t*(float64(to.R)-float64(from.R))),
+				uint8(float64(from.G) + t*(float64(to.G)-float64(from.G))),
+				uint8(float64(from.B) + t*(float64(to.B)-float64(from.B))),
+				255,
+			})
+		}
+	}
+
+	var encode func(io.Writer) error
+	switch format {
+	case "png":
+		encode = func(w io.Writer) error { return png.Encode(w, img) }
+	case "jpeg", "jpg":
+		format = "jpeg"
+		encode = func(w io.Writer) error { return jpeg.Encode(w, img, nil) }
+	case "gif":
+		encode = func(w io.Writer) error { return gif.Encode(w, img, nil) }
+	default:
+		return nil, 0, "", "", fmt.Errorf("unsupported image format %q", format)
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return nil, 0, "", "", err
+	}
+	contentType := "image/" + format
+	return bytes.NewReader(buf.Bytes()), int64(buf.Len()), "image" + extensionFor(contentType), contentType, nil
+}
+
+// maxFileSize caps the size of generated files. They are streamed, but a
+// larger upload is most likely a typo.
+const maxFileSize = 1 << 30
+
+// parseSize parses sizes such as `512`, `10KB` or `5MB` (binary units), up to
+// maxFileSize.
+func parseSize(arg string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(arg))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, multiplier = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(n >= 0) {
+		return 0, fmt.Errorf("invalid size %q", arg)
+	}
+	size := n * float64(multiplier)
+	if size > maxFileSize {
+		return 0, fmt.Errorf("size %s is larger than 1GB", arg)
+	}
+	return int64(size), nil
+}
+
+// extensionFor returns the usual file extension of a content type.
+func extensionFor(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "text/plain":
+		return ".txt"
+	}
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMultipartLength(t *testing.T) {
+	r := newTestRunner(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("some notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content := "inline ü"
+	value := "v"
+
+	var uploads []*upload
+	for _, spec := range []FileSpec{
+		{Path: "[[file: 10KB, application/pdf]]"},
+		{Path: "[[image: gif, 20x10]]"},
+		{Path: "notes.txt", Headers: map[string]string{"X-Checksum": "abc"}},
+		{Content: &content, Filename: `a "quoted" name.txt`},
+		{JSON: map[string]interface{}{"title": "report"}},
+	} {
+		u, err := r.openUpload("file", spec, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploads = append(uploads, u)
+	}
+	u, err := r.openPart(Part{Name: "field", Value: &value}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploads = append(uploads, u)
+	form := map[string]interface{}{"tags[]": []interface{}{"a", "b"}, "name": "x"}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	want := multipartLength(w.Boundary(), form, uploads)
+	if err := writeMultipart(w, form, uploads); err != nil {
+		t.Fatal(err)
+	}
+	if want != int64(buf.Len()) {
+		t.Errorf("multipartLength = %d, written body has %d bytes", want, buf.Len())
+	}
+}
+
+func TestMultipartLengthUnknownSize(t *testing.T) {
+	uploads := []*upload{{field: "f", content: bytes.NewReader(nil), size: -1}}
+	if n := multipartLength("b", nil, uploads); n != -1 {
+		t.Errorf("multipartLength = %d, want -1 for an unknown size", n)
+	}
+}
+
+func TestGenerateFileSize(t *testing.T) {
+	content, size, filename, contentType, err := generateFile([]string{"3KB", "application/pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content)
+	if size != 3072 || len(data) != 3072 {
+		t.Errorf("size = %d with %d bytes, want 3072", size, len(data))
+	}
+	if filename != "file.pdf" || contentType != "application/pdf" || !bytes.HasPrefix(data, []byte("%PDF")) {
+		t.Errorf("got %s (%s) starting with %q", filename, contentType, data[:8])
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"10KB", 10 << 10, false},
+		{" 1.5 mb ", 3 << 19, false},
+		{"1GB", 1 << 30, false},
+		{"2GB", 0, true},
+		{"99999999GB", 0, true},
+		{"1e300", 0, true},
+		{"-1KB", 0, true},
+		{"NaN", 0, true},
+		{"large", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGenerateImage(t *testing.T) {
+	content, size, _, contentType, err := generateImage([]string{"png", "30x20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content)
+	if int64(len(data)) != size {
+		t.Errorf("size = %d, image has %d bytes", size, len(data))
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil || format != "png" || contentType != "image/png" {
+		t.Fatalf("decoded %q (%s): %v", format, contentType, err)
+	}
+	if b := img.Bounds(); b.Dx() != 30 || b.Dy() != 20 {
+		t.Errorf("image is %dx%d, want 30x20", b.Dx(), b.Dy())
+	}
+
+	for _, dims := range []string{"0x10", "10", "5000x5000", "100000x100000"} {
+		if _, _, _, _, err := generateImage([]string{"png", dims}); err == nil {
+			t.Errorf("generateImage(%s) did not fail", dims)
+		}
+	}
+}
+
+func TestFileListAndPartsYAML(t *testing.T) {
+	var req struct {
+		Files map[string]FileList `yaml:"files"`
+		Parts []Part              `yaml:"parts"`
+	}
+	err := yaml.Unmarshal([]byte(`
+files:
+  doc: a.pdf
+  attachments: [b.pdf, {content: hi, filename: x.txt}]
+parts:
+  - {name: meta, json: {title: T}}
+  - {name: "tags[]", value: q3}
+`), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hi := "hi"
+	q3 := "q3"
+	wantFiles := map[string]FileList{
+		"doc":         {{Path: "a.pdf"}},
+		"attachments": {{Path: "b.pdf"}, {Content: &hi, Filename: "x.txt"}},
+	}
+	wantParts := []Part{
+		{Name: "meta", File: FileSpec{JSON: map[string]interface{}{"title": "T"}}},
+		{Name: "tags[]", Value: &q3},
+	}
+	if !reflect.DeepEqual(req.Files, wantFiles) {
+		t.Errorf("files = %+v, want %+v", req.Files, wantFiles)
+	}
+	if !reflect.DeepEqual(req.Parts, wantParts) {
+		t.Errorf("parts = %+v, want %+v", req.Parts, wantParts)
+	}
+
+	var parts []Part
+	if err := yaml.Unmarshal([]byte(`[{value: x}]`), &parts); err == nil {
+		t.Error("a part without a name was accepted")
+	}
+}
+
+func TestMultipartParts(t *testing.T) {
+	r := newTestRunner(t)
+	r.Environment["title"] = "Q3"
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"report.pdf": "%PDF"})
+	finance, comment := "finance", "Ünïcode"
+
+	var uploads []*upload
+	for _, part := range []Part{
+		{Name: "meta", File: FileSpec{JSON: map[string]interface{}{"title": "{{title}}"}}},
+		{Name: "tags[]", Value: &finance},
+		{Name: "file", File: FileSpec{Path: "report.pdf", Headers: map[string]string{"X-Checksum": "abc"}}},
+		{Name: "comment", Value: &comment, File: FileSpec{ContentType: "text/plain; charset=utf-8"}},
+	} {
+		u, err := r.openPart(part, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploads = append(uploads, u)
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := writeMultipart(w, map[string]interface{}{"name": "x"}, uploads); err != nil {
+		t.Fatal(err)
+	}
+
+	type part struct{ name, filename, contentType, checksum, body string }
+	want := []part{
+		{"name", "", "", "", "x"},
+		{"meta", "", "application/json", "", `{"title":"Q3"}`},
+		{"tags[]", "", "", "", "finance"},
+		{"file", "report.pdf", "application/pdf", "abc", "%PDF"},
+		{"comment", "", "text/plain; charset=utf-8", "", "Ünïcode"},
+	}
+	reader := multipart.NewReader(&buf, w.Boundary())
+	for i := 0; ; i++ {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("body has %d parts, want %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		got := part{p.FormName(), p.FileName(), p.Header.Get("Content-Type"), p.Header.Get("X-Checksum"), string(body)}
+		if i >= len(want) || got != want[i] {
+			t.Errorf("part %d = %+v", i, got)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pluginDir is where executable plugins are discovered at startup.
+const pluginDir = ".hepi/plugins"
+
+// pluginTimeout bounds how long a plugin may take to answer a call.
+const pluginTimeout = 10 * time.Second
+
+// Plugin is an external executable speaking JSON-RPC 2.0 over its standard
+// input and output, one message per line.
+//
+// On startup hepi calls `initialize`, to which the plugin answers with the
+// names it provides: {"generators": [...], "signers": [...], "assertions": [...]}.
+// Afterwards hepi calls `generate`, `sign` and `assert` as needed, and
+// `shutdown` before exiting.
+type Plugin struct {
+	Name       string
+	Path       string
+	Generators []string `json:"generators"`
+	Signers    []string `json:"signers"`
+	Assertions []string `json:"assertions"`
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	nextID int
+	mu     sync.Mutex
+	// failed is set once the plugin stopped answering and was killed.
+	failed error
+}
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// pluginRequest is the outgoing request passed to and returned by `sign`.
+type pluginRequest struct {
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    *string           `json:"body,omitempty"`
+}
+
+// pluginResponse is the received response passed to `assert`.
+type pluginResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// loadPlugins starts every executable in dir and registers what it provides.
+// A missing directory simply means there are no plugins.
+func loadPlugins(dir string) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%sfailed to read plugin directory %q: %w%s", colorRed, dir, err, colorReset)
+	}
+
+	var plugins []*Plugin
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			continue
+		}
+		p, err := startPlugin(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			for _, started := range plugins {
+				started.Close()
+			}
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+func startPlugin(path string) (*Plugin, error) {
+	p := &Plugin{Name: filepath.Base(path), Path: path}
+	p.cmd = exec.Command(path)
+	p.cmd.Stderr = os.Stderr
+
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("%sfailed to start plugin %q: %w%s", colorRed, p.Name, err, colorReset)
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("%sfailed to start plugin %q: %w%s", colorRed, p.Name, err, colorReset)
+	}
+	p.stdin = stdin
+	p.stdout = bufio.NewReader(stdout)
+
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%sfailed to start plugin %q: %w%s", colorRed, p.Name, err, colorReset)
+	}
+
+	if err := p.call("initialize", map[string]interface{}{"protocol": 1}, p); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("%sfailed to initialize plugin %q: %v%s", colorRed, p.Name, err, colorReset)
+	}
+	return p, nil
+}
+
+// call sends a JSON-RPC request and decodes the result into out. A plugin
+// that does not answer within pluginTimeout is killed.
+func (p *Plugin) call(method string, params, out interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failed != nil {
+		return p.failed
+	}
+
+	p.nextID++
+	data, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: p.nextID, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	type reply struct {
+		line []byte
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+			replies <- reply{err: err}
+			return
+		}
+		line, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			err = fmt.Errorf("plugin closed its output: %w", err)
+		}
+		replies <- reply{line, err}
+	}()
+
+	var line []byte
+	select {
+	case res := <-replies:
+		if res.err != nil {
+			return res.err
+		}
+		line = res.line
+	case <-time.After(pluginTimeout):
+		p.cmd.Process.Kill()
+		p.failed = fmt.Errorf("plugin did not answer %s within %v and was stopped", method, pluginTimeout)
+		return p.failed
+	}
+
+	var resp rpcResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("invalid response %q: %w", line, err)
+	}
+	if resp.ID != p.nextID {
+		return fmt.Errorf("response id %d does not match request id %d", resp.ID, p.nextID)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s (code %d)", resp.Error.Message, resp.Error.Code)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+// Close asks the plugin to shut down and reaps the process.
+func (p *Plugin) Close() {
+	_ = p.call("shutdown", nil, nil)
+	p.stdin.Close()
+
+	done := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		p.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// registerPlugins adds plugin generators to the generators of this run and
+// indexes signers and assertions by name. A plugin generator may not replace
+// a built-in generator or one of another plugin.
+func (r *Runner) registerPlugins(plugins []*Plugin) error {
+	r.Plugins = plugins
+	r.pluginSigners = make(map[string]*Plugin)
+	r.pluginAssertions = make(map[string]*Plugin)
+
+	for _, p := range plugins {
+		for _, name := range p.Generators {
+			p, name := p, name
+			if _, exists := r.generators[name]; exists {
+				return fmt.Errorf("%splugin %q: generator %q already exists%s", colorRed, p.Name, name, colorReset)
+			}
+			r.generators[name] = func(args []string) (string, error) {
+				var out string
+				if err := p.call("generate", map[string]interface{}{"name": name, "args": args}, &out); err != nil {
+					return "", fmt.Errorf("plugin %q: %v", p.Name, err)
+				}
+				return out, nil
+			}
+		}
+		for _, name := range p.Signers {
+			r.pluginSigners[name] = p
+		}
+		for _, name := range p.Assertions {
+			r.pluginAssertions[name] = p
+		}
+	}
+	return nil
+}
+
+// signRequest lets a plugin signer modify the outgoing request.
+func (r *Runner) signRequest(auth *AuthConfig, req *scriptRequest) error {
+	p, ok := r.pluginSigners[auth.Signer]
+	if !ok {
+		return fmt.Errorf("%sauth signer %q not provided by any plugin%s", colorRed, auth.Signer, colorReset)
+	}
+
+	body := string(req.Body)
+	params := map[string]interface{}{
+		"name":    auth.Signer,
+		"args":    r.substituteMap(auth.Args),
+		"request": pluginRequest{Method: req.Method, URL: req.URL, Headers: req.Headers, Body: &body},
+	}
+	var signed pluginRequest
+	if err := p.call("sign", params, &signed); err != nil {
+		return fmt.Errorf("%splugin %q failed to sign request: %v%s", colorRed, p.Name, err, colorReset)
+	}
+
+	if signed.Method != "" {
+		req.Method = signed.Method
+	}
+	if signed.URL != "" {
+		req.URL = signed.URL
+	}
+	for k, v := range signed.Headers {
+		req.Headers[k] = v
+	}
+	if signed.Body != nil {
+		req.Body = []byte(*signed.Body)
+	}
+	return nil
+}
+
+// pluginAssert evaluates a plugin-provided assertion.
+func (r *Runner) pluginAssert(name, value string, args map[string]interface{}, resp *scriptResponse) (bool, string, error) {
+	p, ok := r.pluginAssertions[name]
+	if !ok {
+		return false, "", fmt.Errorf("assertion %q not provided by any plugin", name)
+	}
+
+	params := map[string]interface{}{
+		"name":     name,
+		"value":    value,
+		"args":     r.substituteMap(args),
+		"response": pluginResponse{Status: resp.Status, Headers: resp.Headers, Body: string(resp.Body)},
+	}
+	var result struct {
+		OK      bool   `json:"ok"`
+		Message string `json:"message"`
+	}
+	if err := p.call("assert", params, &result); err != nil {
+		return false, "", fmt.Errorf("plugin %q failed: %v", p.Name, err)
+	}
+	return result.OK, result.Message, nil
+}
+
+// pluginNames lists everything the loaded plugins provide, for help output.
+func (r *Runner) pluginNames() []string {
+	var names []string
+	for _, p := range r.Plugins {
+		for _, name := range p.Generators {
+			names = append(names, fmt.Sprintf("[[%s]] (%s)", name, p.Name))
+		}
+		for _, name := range p.Signers {
+			names = append(names, fmt.Sprintf("signer %s (%s)", name, p.Name))
+		}
+		for _, name := range p.Assertions {
+			names = append(names, fmt.Sprintf("assertion %s (%s)", name, p.Name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestRegisterPluginsRejectsConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugins []*Plugin
+	}{
+		{"built-in", []*Plugin{{Name: "p", Generators: []string{"email"}}}},
+		{"other plugin", []*Plugin{
+			{Name: "a", Generators: []string{"account"}},
+			{Name: "b", Generators: []string{"account"}},
+		}},
+	}
+	for _, tt := range tests {
+		if err := newTestRunner(t).registerPlugins(tt.plugins); err == nil {
+			t.Errorf("%s: a plugin replaced an existing generator", tt.name)
+		}
+	}
+}
+
+func TestPluginGeneratorsArePerRunner(t *testing.T) {
+	r := newTestRunner(t)
+	if err := r.registerPlugins([]*Plugin{{Name: "p", Generators: []string{"account"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.generators["account"]; !ok {
+		t.Fatal("plugin generator was not registered")
+	}
+	if _, ok := newTestRunner(t).generators["account"]; ok {
+		t.Error("a second runner sees the plugin generator of the first")
+	}
+	if _, ok := Generators["account"]; ok {
+		t.Error("plugin generator was added to the built-in generators")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// QueryParam is a single query parameter. Its value may be a scalar, a list
+// or a mapping.
+type QueryParam struct {
+	Key   string
+	Value interface{}
+}
+
+// QueryParams keeps query parameters in the order they are written in YAML.
+type QueryParams []QueryParam
+
+func (p *QueryParams) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: params must be a mapping", value.Line)
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		var v interface{}
+		if err := value.Content[i+1].Decode(&v); err != nil {
+			return err
+		}
+		*p = append(*p, QueryParam{Key: value.Content[i].Value, Value: v})
+	}
+	return nil
+}
+
+// mergeParams layers child over base. Overridden keys keep their position,
+// new keys are appended and mappings are deep-merged.
+func mergeParams(base, child QueryParams) QueryParams {
+	if base == nil && child == nil {
+		return nil
+	}
+	merged := append(QueryParams{}, base...)
+	for _, param := range child {
+		found := false
+		for i := range merged {
+			if merged[i].Key != param.Key {
+				continue
+			}
+			baseMap, ok1 := merged[i].Value.(map[string]interface{})
+			childMap, ok2 := param.Value.(map[string]interface{})
+			if ok1 && ok2 {
+				merged[i].Value = deepMerge(baseMap, childMap)
+			} else {
+				merged[i].Value = param.Value
+			}
+			found = true
+		}
+		if !found {
+			merged = append(merged, param)
+		}
+	}
+	return merged
+}
+
+// Query styles for list values.
+const (
+	queryStyleRepeat   = "repeat"   // ids=1&ids=2
+	queryStyleComma    = "comma"    // ids=1,2
+	queryStyleBrackets = "brackets" // ids[]=1&ids[]=2
+)
+
+// applyParams adds the substituted params to rawURL. Existing query pairs
+// are kept in order unless a param with the same key replaces them, which
+// includes the `key[]` and `key[sub]` pairs of lists and mappings. Lists use
+// style and mappings are encoded as deepObject (`filter[name]=x`).
+func (r *Runner) applyParams(rawURL string, params QueryParams, style string) (string, error) {
+	switch style {
+	case "":
+		style = queryStyleRepeat
+	case queryStyleRepeat, queryStyleComma, queryStyleBrackets:
+	default:
+		return "", fmt.Errorf("%sunknown query_style %q (expected repeat, comma or brackets)%s", colorRed, style, colorReset)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%sfailed to parse URL %q: %w%s", colorRed, rawURL, err, colorReset)
+	}
+
+	var pairs []string
+	replaced := make(map[string]bool)
+	for _, param := range params {
+		key := r.substitute(param.Key)
+		value := r.substituteSlice([]interface{}{param.Value})[0]
+		pairs = append(pairs, encodeParam(key, value, style)...)
+		replaced[key] = true
+	}
+	var kept []string
+	if u.RawQuery != "" {
+		for _, pair := range strings.Split(u.RawQuery, "&") {
+			key, _, _ := strings.Cut(pair, "=")
+			if unescaped, err := url.QueryUnescape(key); err == nil {
+				key = unescaped
+			}
+			base, _, _ := strings.Cut(key, "[")
+			if !replaced[key] && !replaced[base] {
+				kept = append(kept, pair)
+			}
+		}
+	}
+
+	u.RawQuery = strings.Join(append(kept, pairs...), "&")
+	return u.String(), nil
+}
+
+// encodeParam encodes one parameter into `key=value` pairs.
+func encodeParam(key string, value interface{}, style string) []string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var pairs []string
+		for _, k := range sortedKeys(v) {
+			pairs = append(pairs, encodeParam(key+"["+k+"]", v[k], style)...)
+		}
+		return pairs
+	case []interface{}:
+		switch style {
+		case queryStyleComma:
+			values := make([]string, len(v))
+			for i, item := range v {
+				values[i] = url.QueryEscape(fmt.Sprintf("%v", item))
+			}
+			return []string{queryEscape(key) + "=" + strings.Join(values, ",")}
+		case queryStyleBrackets:
+			key += "[]"
+		}
+		var pairs []string
+		for _, item := range v {
+			pairs = append(pairs, encodeParam(key, item, style)...)
+		}
+		return pairs
+	case nil:
+		return []string{queryEscape(key) + "="}
+	}
+	return []string{queryEscape(key) + "=" + url.QueryEscape(fmt.Sprintf("%v", value))}
+}
+
+// queryEscape escapes a parameter name, keeping brackets readable.
+func queryEscape(s string) string {
+	return strings.NewReplacer("%5B", "[", "%5D", "]").Replace(url.QueryEscape(s))
+}
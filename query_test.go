@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestApplyParamsReplacesURLPairs(t *testing.T) {
+	tests := []struct {
+		url    string
+		params QueryParams
+		want   string
+	}{
+		{"http://api.test/?a=1&b=2", QueryParams{{"a", "3"}}, "http://api.test/?b=2&a=3"},
+		{"http://api.test/?ids[]=1&x=1", QueryParams{{"ids", []interface{}{2}}}, "http://api.test/?x=1&ids=2"},
+		{
+			"http://api.test/?filter[status]=closed&filter[a][b]=1&filters=1",
+			QueryParams{{"filter", map[string]interface{}{"status": "open"}}},
+			"http://api.test/?filters=1&filter[status]=open",
+		},
+		{"http://api.test/?filter%5Bstatus%5D=closed", QueryParams{{"filter", map[string]interface{}{"status": "open"}}}, "http://api.test/?filter[status]=open"},
+		{"http://api.test/?filter[a]=1&filter[b]=2", QueryParams{{"filter[a]", "3"}}, "http://api.test/?filter[b]=2&filter[a]=3"},
+	}
+	for _, tt := range tests {
+		got, err := newTestRunner(t).applyParams(tt.url, tt.params, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("applyParams(%q, %v) = %q, want %q", tt.url, tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestApplyParamsStyles(t *testing.T) {
+	params := QueryParams{
+		{"q", "a b&c"},
+		{"ids", []interface{}{1, 2}},
+		{"filter", map[string]interface{}{"status": "open", "range": map[string]interface{}{"min": 1}}},
+		{"empty", nil},
+	}
+	tests := []struct {
+		style, want string
+	}{
+		{"", "q=a+b%26c&ids=1&ids=2&filter[range][min]=1&filter[status]=open&empty="},
+		{"repeat", "q=a+b%26c&ids=1&ids=2&filter[range][min]=1&filter[status]=open&empty="},
+		{"comma", "q=a+b%26c&ids=1,2&filter[range][min]=1&filter[status]=open&empty="},
+		{"brackets", "q=a+b%26c&ids[]=1&ids[]=2&filter[range][min]=1&filter[status]=open&empty="},
+	}
+	for _, tt := range tests {
+		got, err := newTestRunner(t).applyParams("http://api.test/items?expand=all", params, tt.style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "http://api.test/items?expand=all&" + tt.want; got != want {
+			t.Errorf("style %q: %s, want %s", tt.style, got, want)
+		}
+	}
+
+	if _, err := newTestRunner(t).applyParams("http://api.test/", params, "pipes"); err == nil {
+		t.Error("an unknown query_style was accepted")
+	}
+}
+
+func TestApplyParamsSubstitutes(t *testing.T) {
+	r := newTestRunner(t)
+	r.Environment["key"] = "tag"
+	r.Environment["id"] = "7/8"
+	got, err := r.applyParams("http://api.test/", QueryParams{{"{{key}}", "{{id}}"}, {"list", []interface{}{"{{id}}"}}}, "comma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://api.test/?tag=7%2F8&list=7%2F8"; got != want {
+		t.Errorf("applyParams = %s, want %s", got, want)
+	}
+}
+
+func TestQueryParamsKeepOrder(t *testing.T) {
+	var base, child QueryParams
+	if err := yaml.Unmarshal([]byte("z: 1\na: [1, 2]\nm: {x: 1, y: 2}\n"), &base); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte("b: 3\na: 4\nm: {y: 3}\n"), &child); err != nil {
+		t.Fatal(err)
+	}
+	want := QueryParams{
+		{"z", 1},
+		{"a", 4},
+		{"m", map[string]interface{}{"x": 1, "y": 3}},
+		{"b", 3},
+	}
+	if got := mergeParams(base, child); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeParams = %v, want %v", got, want)
+	}
+	if err := yaml.Unmarshal([]byte("[a, b]"), &base); err == nil {
+		t.Error("a list of params was accepted")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// redactedHeaders carry credentials, which are not written to the state file.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// redacted is stored in place of the value of a redacted header.
+const redacted = "[redacted]"
+
+// recordRequest stores the fully resolved outgoing request and the response
+// metadata in the state under `$requests`, so later requests can reference
+// them as {{name.request.json.email}}, {{name.response.status}},
+// {{name.timing.ttfb_ms}} or {{name.redirects.0.location}}. Headers carrying
+// credentials are redacted.
+func (r *Runner) recordRequest(name string, req *scriptRequest, resp *http.Response, duration time.Duration, timing *requestTiming, hops []redirectHop) {
+	sentHeaders := make(map[string]interface{}, len(req.Headers))
+	for k, v := range req.Headers {
+		if redactedHeaders[http.CanonicalHeaderKey(k)] {
+			v = redacted
+		}
+		sentHeaders[k] = v
+	}
+	sent := map[string]interface{}{
+		"method":  req.Method,
+		"url":     req.URL,
+		"headers": sentHeaders,
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		sent["query"] = valuesToMap(u.Query())
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Headers["Content-Type"])
+	var parsed interface{}
+	switch {
+	case len(req.Body) == 0:
+	case mediaType == "application/json" && json.Unmarshal(req.Body, &parsed) == nil:
+		sent["json"] = parsed
+	case mediaType == "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(req.Body)); err == nil {
+			sent["form"] = valuesToMap(form)
+		}
+	case mediaType == "multipart/form-data":
+		// Uploaded files are not copied into the state
+	default:
+		sent["body"] = string(req.Body)
+	}
+
+	headers := make(map[string]interface{})
+	for k, v := range resp.Header {
+		switch {
+		case redactedHeaders[k]:
+			headers[k] = redacted
+		case len(v) == 1:
+			headers[k] = v[0]
+		default:
+			headers[k] = stringsToInterface(v)
+		}
+	}
+
+	received := map[string]interface{}{
+		"status":         resp.StatusCode,
+		"proto":          resp.Proto,
+		"headers":        headers,
+		"duration_ms":    duration.Milliseconds(),
+		"url":            resp.Request.URL.String(),
+		"redirect_count": len(hops),
+	}
+	if resp.TLS != nil {
+		received["tls"] = tlsInfo(resp.TLS)
+	}
+
+	record := map[string]interface{}{
+		"request":  sent,
+		"response": received,
+		"timing":   timing.toMap(),
+	}
+	if len(hops) > 0 {
+		record["redirects"] = redirectsToList(hops)
+	}
+	if len(r.bound) > 0 {
+		vars := make(map[string]interface{}, len(r.bound))
+		for k, v := range r.bound {
+			vars[k] = v
+		}
+		record["vars"] = vars
+	}
+
+	requests, ok := r.State["$requests"].(map[string]interface{})
+	if !ok {
+		requests = make(map[string]interface{})
+		r.State["$requests"] = requests
+	}
+	requests[name] = record
+}
+
+// valuesToMap converts query or form values into a map holding a string for
+// single values and a list for repeated keys.
+func valuesToMap(values url.Values) map[string]interface{} {
+	res := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if len(v) == 1 {
+			res[k] = v[0]
+		} else {
+			res[k] = stringsToInterface(v)
+		}
+	}
+	return res
+}
+
+func stringsToInterface(s []string) []interface{} {
+	res := make([]interface{}, len(s))
+	for i, v := range s {
+		res[i] = v
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRecordRequestRedactsCredentials(t *testing.T) {
+	r := newTestRunner(t)
+	req := &scriptRequest{
+		Method: "GET",
+		URL:    "http://api.test/me?x=1",
+		Headers: map[string]string{
+			"Authorization": "Bearer secret",
+			"Cookie":        "session=secret",
+			"Accept":        "application/json",
+		},
+	}
+	u, _ := url.Parse(req.URL)
+	resp := &http.Response{
+		StatusCode: 200,
+		Proto:      "HTTP/1.1",
+		Header: http.Header{
+			"Set-Cookie":   {"session=new", "other=1"},
+			"Content-Type": {"application/json"},
+		},
+		Request: &http.Request{URL: u},
+	}
+	r.recordRequest("me", req, resp, 0, &requestTiming{}, nil)
+
+	record := r.State["$requests"].(map[string]interface{})["me"].(map[string]interface{})
+	sent := record["request"].(map[string]interface{})["headers"].(map[string]interface{})
+	received := record["response"].(map[string]interface{})["headers"].(map[string]interface{})
+
+	for _, h := range []interface{}{sent["Authorization"], sent["Cookie"], received["Set-Cookie"]} {
+		if h != redacted {
+			t.Errorf("credential header stored as %v, want %s", h, redacted)
+		}
+	}
+	if sent["Accept"] != "application/json" || received["Content-Type"] != "application/json" {
+		t.Errorf("other headers were not stored: %v, %v", sent, received)
+	}
+	if query := record["request"].(map[string]interface{})["query"].(map[string]interface{}); query["x"] != "1" {
+		t.Errorf("query = %v", query)
+	}
+}
+
+func TestBoundValuesStoredOnce(t *testing.T) {
+	r := newConfigRunner(t, `
+environments:
+  test: {}
+requests:
+  signup: {method: POST, url: "{{host}}/", json: {email: "[[email as signup_email]]"}}
+`)
+	if err := r.runRequest("signup", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	email := r.Vars["signup_email"]
+	if got := r.substitute("{{signup.vars.signup_email}}"); got != email {
+		t.Errorf("{{signup.vars.signup_email}} = %q, want %v", got, email)
+	}
+	run := r.State["$run"].(map[string]interface{})["requests"].(map[string]interface{})["signup"].(map[string]interface{})
+	if _, ok := run["vars"]; ok {
+		t.Error("bound values are stored under $run as well")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// defaultMaxRedirects matches the limit of Go's default HTTP client.
+const defaultMaxRedirects = 10
+
+// redirectHop is one redirect followed while sending a request.
+type redirectHop struct {
+	Status   int
+	Text     string
+	URL      string
+	Location string
+}
+
+// checkRedirect returns the redirect policy of a request. The request's
+// follow_redirects and max_redirects override the -follow-redirects and
+// -max-redirects flags. Followed redirects are appended to hops.
+func (r *Runner) checkRedirect(req Request, hops *[]redirectHop) func(*http.Request, []*http.Request) error {
+	follow := r.FollowRedirects
+	if req.FollowRedirects != nil {
+		follow = *req.FollowRedirects
+	}
+	max := r.MaxRedirects
+	if req.MaxRedirects != nil {
+		max = *req.MaxRedirects
+	}
+
+	return func(next *http.Request, via []*http.Request) error {
+		if !follow {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		*hops = append(*hops, redirectHop{
+			Status:   next.Response.StatusCode,
+			Text:     next.Response.Status,
+			URL:      via[len(via)-1].URL.String(),
+			Location: next.URL.String(),
+		})
+		return nil
+	}
+}
+
+// printRedirects shows the redirects that led to the final response.
+func printRedirects(hops []redirectHop) {
+	for _, hop := range hops {
+		fmt.Printf("Redirect: %s%s%s %s -> %s\n", colorYellow, hop.Text, colorReset, hop.URL, hop.Location)
+	}
+}
+
+// redirectsToList describes the redirect chain for the state.
+func redirectsToList(hops []redirectHop) []interface{} {
+	res := make([]interface{}, len(hops))
+	for i, hop := range hops {
+		res[i] = map[string]interface{}{
+			"status":   hop.Status,
+			"url":      hop.URL,
+			"location": hop.Location,
+		}
+	}
+	return res
+}
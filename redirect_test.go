@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newRedirectServer answers /hop/N with a redirect to /hop/N-1 and /hop/0
+// with 200.
+func newRedirectServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+		if n > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	srv := newRedirectServer(t)
+	r := newConfigRunner(t, strings.ReplaceAll(`
+environments:
+  test: {}
+requests:
+  follow: {url: "{{redirects}}/hop/2"}
+  manual: {url: "{{redirects}}/hop/2", follow_redirects: false}
+  limited: {url: "{{redirects}}/hop/3", max_redirects: 2}
+  enough: {url: "{{redirects}}/hop/3", max_redirects: 3}
+`, "{{redirects}}", srv.URL))
+
+	tests := []struct {
+		name    string
+		status  int
+		hops    int
+		wantErr bool
+	}{
+		{"follow", 200, 2, false},
+		{"manual", 302, 0, false},
+		{"limited", 0, 0, true},
+		{"enough", 200, 3, false},
+	}
+	for _, tt := range tests {
+		err := r.runRequest(tt.name, nil)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "stopped after 2 redirects") {
+				t.Errorf("%s: error = %v, want the redirect limit", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		record := r.State["$requests"].(map[string]interface{})[tt.name].(map[string]interface{})
+		resp := record["response"].(map[string]interface{})
+		if resp["status"] != tt.status || resp["redirect_count"] != tt.hops {
+			t.Errorf("%s: status %v after %v redirects, want %d after %d", tt.name, resp["status"], resp["redirect_count"], tt.status, tt.hops)
+		}
+	}
+}
+
+func TestRedirectFlagsAndChain(t *testing.T) {
+	srv := newRedirectServer(t)
+	r := newConfigRunner(t, strings.ReplaceAll(`
+environments:
+  test: {}
+requests:
+  chain: {url: "{{redirects}}/hop/2"}
+  forced: {url: "{{redirects}}/hop/1", follow_redirects: true}
+`, "{{redirects}}", srv.URL))
+	r.FollowRedirects = false
+
+	if err := r.runRequest("chain", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.runRequest("forced", nil); err != nil {
+		t.Fatal(err)
+	}
+	requests := r.State["$requests"].(map[string]interface{})
+	if _, ok := requests["chain"].(map[string]interface{})["redirects"]; ok {
+		t.Error("redirects recorded with -follow-redirects=false")
+	}
+
+	want := []interface{}{
+		map[string]interface{}{"status": 302, "url": srv.URL + "/hop/1", "location": srv.URL + "/hop/0"},
+	}
+	if got := requests["forced"].(map[string]interface{})["redirects"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("redirects = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// scriptTimeout bounds how long a single hook may run.
+const scriptTimeout = 5 * time.Second
+
+// scriptRequest is the outgoing request exposed to a `pre` hook.
+type scriptRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    []byte
+}
+
+// scriptResponse is the received response exposed to a `post` hook.
+type scriptResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+	JSON    interface{}
+}
+
+// errStepFailed marks a failure raised by a script through hepi.fail.
+var errStepFailed = errors.New("step failed")
+
+// runScript executes a Lua hook in a sandboxed interpreter. Only the base,
+// table, string and math libraries are available. The script sees the
+// request and/or response as tables and may modify them; entries of the
+// `state` and `vars` tables the script changes are written back to the runner.
+func (r *Runner) runScript(hook, source string, req *scriptRequest, resp *scriptResponse) error {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "module", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	var failMessage string
+	hepi := L.NewTable()
+	L.SetFuncs(hepi, map[string]lua.LGFunction{
+		"fail": func(L *lua.LState) int {
+			failMessage = L.OptString(1, "failed by script")
+			L.RaiseError("%s", failMessage)
+			return 0
+		},
+		"log": func(L *lua.LState) int {
+			parts := make([]string, L.GetTop())
+			for i := range parts {
+				parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+			}
+			fmt.Printf("%s[%s]%s %s\n", colorPurple, hook, colorReset, strings.Join(parts, " "))
+			return 0
+		},
+		"json_encode": func(L *lua.LState) int {
+			data, err := json.Marshal(fromLua(L.Get(1)))
+			if err != nil {
+				L.RaiseError("json_encode: %v", err)
+			}
+			L.Push(lua.LString(data))
+			return 1
+		},
+		"json_decode": func(L *lua.LState) int {
+			var v interface{}
+			if err := json.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+				L.RaiseError("json_decode: %v", err)
+			}
+			L.Push(toLua(L, v))
+			return 1
+		},
+		"sha256": func(L *lua.LState) int {
+			sum := sha256.Sum256([]byte(L.CheckString(1)))
+			L.Push(lua.LString(hex.EncodeToString(sum[:])))
+			return 1
+		},
+		"hmac_sha256": func(L *lua.LState) int {
+			mac := hmac.New(sha256.New, []byte(L.CheckString(1)))
+			mac.Write([]byte(L.CheckString(2)))
+			L.Push(lua.LString(hex.EncodeToString(mac.Sum(nil))))
+			return 1
+		},
+		"base64": func(L *lua.LState) int {
+			L.Push(lua.LString(base64.StdEncoding.EncodeToString([]byte(L.CheckString(1)))))
+			return 1
+		},
+	})
+	L.SetGlobal("hepi", hepi)
+	L.SetGlobal("env", toLua(L, r.Environment))
+	L.SetGlobal("state", toLua(L, r.State))
+	L.SetGlobal("vars", toLua(L, r.Vars))
+	// Values as they come back from Lua unchanged, to tell which ones the
+	// script modified
+	stateBefore := fromLua(toLua(L, r.State))
+	varsBefore := fromLua(toLua(L, r.Vars))
+
+	if req != nil {
+		t := L.NewTable()
+		t.RawSetString("method", lua.LString(req.Method))
+		t.RawSetString("url", lua.LString(req.URL))
+		t.RawSetString("headers", toLua(L, stringMapToInterface(req.Headers)))
+		t.RawSetString("body", lua.LString(req.Body))
+		L.SetGlobal("request", t)
+	}
+	var jsonBefore interface{}
+	if resp != nil {
+		jsonBefore = fromLua(toLua(L, resp.JSON))
+		t := L.NewTable()
+		t.RawSetString("status", lua.LNumber(resp.Status))
+		t.RawSetString("headers", toLua(L, stringMapToInterface(resp.Headers)))
+		t.RawSetString("body", lua.LString(resp.Body))
+		t.RawSetString("json", toLua(L, resp.JSON))
+		L.SetGlobal("response", t)
+	}
+
+	if err := L.DoString(source); err != nil {
+		if failMessage != "" {
+			return fmt.Errorf("%s%s hook: %w: %s%s", colorRed, hook, errStepFailed, failMessage, colorReset)
+		}
+		return fmt.Errorf("%s%s hook error: %v%s", colorRed, hook, err, colorReset)
+	}
+
+	if writeBack(L.GetGlobal("state"), r.State, stateBefore) {
+		r.saveState()
+	}
+	writeBack(L.GetGlobal("vars"), r.Vars, varsBefore)
+
+	if req != nil {
+		if t, ok := L.GetGlobal("request").(*lua.LTable); ok {
+			req.Method = L.ToStringMeta(t.RawGetString("method")).String()
+			req.URL = L.ToStringMeta(t.RawGetString("url")).String()
+			req.Headers = interfaceToStringMap(fromLua(t.RawGetString("headers")))
+			req.Body = []byte(L.ToStringMeta(t.RawGetString("body")).String())
+		}
+	}
+	if resp != nil {
+		if t, ok := L.GetGlobal("response").(*lua.LTable); ok {
+			resp.Body = []byte(L.ToStringMeta(t.RawGetString("body")).String())
+			if v := t.RawGetString("json"); !reflect.DeepEqual(fromLua(v), jsonBefore) {
+				resp.JSON = fromLuaAs(v, resp.JSON)
+			}
+		}
+	}
+
+	return nil
+}
+
+// toLua converts a decoded JSON/YAML value into a Lua value.
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(val)
+	case string:
+		return lua.LString(val)
+	case int:
+		return lua.LNumber(val)
+	case int64:
+		return lua.LNumber(val)
+	case float64:
+		return lua.LNumber(val)
+	case map[string]interface{}:
+		t := L.NewTable()
+		for k, item := range val {
+			t.RawSetString(k, toLua(L, item))
+		}
+		return t
+	case []interface{}:
+		t := L.NewTable()
+		for _, item := range val {
+			t.Append(toLua(L, item))
+		}
+		return t
+	default:
+		return lua.LString(fmt.Sprintf("%v", val))
+	}
+}
+
+// writeBack applies the changes a script made to a `state` or `vars` table
+// to m and reports whether anything changed. before holds the table's values
+// as converted back unchanged; entries still equal to it keep their original
+// Go values, so untouched data is not altered by the round trip through Lua.
+// A JSON null is nil in Lua too, so an entry that was null and is still nil
+// is kept rather than deleted.
+func writeBack(v lua.LValue, m map[string]interface{}, before interface{}) bool {
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return false
+	}
+	unchanged, _ := before.(map[string]interface{})
+
+	changed := false
+	for k, orig := range m {
+		item := t.RawGetString(k)
+		if item == lua.LNil && orig == nil {
+			continue
+		}
+		if item == lua.LNil {
+			delete(m, k)
+			changed = true
+		} else if !reflect.DeepEqual(fromLua(item), unchanged[k]) {
+			m[k] = fromLuaAs(item, orig)
+			changed = true
+		}
+	}
+	t.ForEach(func(k, item lua.LValue) {
+		if _, ok := m[k.String()]; !ok {
+			m[k.String()] = fromLua(item)
+			changed = true
+		}
+	})
+	return changed
+}
+
+// fromLua converts a Lua value back into a JSON-compatible Go value. Tables
+// with only consecutive integer keys become slices, all others become maps.
+func fromLua(v lua.LValue) interface{} {
+	return fromLuaAs(v, nil)
+}
+
+// fromLuaAs converts a Lua value like fromLua, keeping the types of like
+// where Lua cannot tell them apart: empty tables stay arrays and whole
+// numbers stay integers when the original value was one.
+func fromLuaAs(v lua.LValue, like interface{}) interface{} {
+	switch val := v.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		return bool(val)
+	case lua.LString:
+		return string(val)
+	case lua.LNumber:
+		f := float64(val)
+		if f == float64(int64(f)) {
+			switch like.(type) {
+			case int:
+				return int(f)
+			case int64:
+				return int64(f)
+			}
+		}
+		return f
+	case *lua.LTable:
+		list, isList := like.([]interface{})
+		if n := val.MaxN(); n > 0 {
+			count := 0
+			val.ForEach(func(lua.LValue, lua.LValue) { count++ })
+			if count == n {
+				res := make([]interface{}, n)
+				for i := 1; i <= n; i++ {
+					var item interface{}
+					if i <= len(list) {
+						item = list[i-1]
+					}
+					res[i-1] = fromLuaAs(val.RawGetInt(i), item)
+				}
+				return res
+			}
+		} else if isList && isEmptyTable(val) {
+			return []interface{}{}
+		}
+		fields, _ := like.(map[string]interface{})
+		res := make(map[string]interface{})
+		val.ForEach(func(k, item lua.LValue) {
+			res[k.String()] = fromLuaAs(item, fields[k.String()])
+		})
+		return res
+	default:
+		return val.String()
+	}
+}
+
+// isEmptyTable reports whether t has no entries.
+func isEmptyTable(t *lua.LTable) bool {
+	empty := true
+	t.ForEach(func(lua.LValue, lua.LValue) { empty = false })
+	return empty
+}
+
+func stringMapToInterface(m map[string]string) map[string]interface{} {
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+func interfaceToStringMap(v interface{}) map[string]string {
+	res := make(map[string]string)
+	m, _ := v.(map[string]interface{})
+	for k, item := range m {
+		res[k] = fmt.Sprintf("%v", item)
+	}
+	return res
+}
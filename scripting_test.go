@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScriptWriteBack(t *testing.T) {
+	tests := []struct {
+		script    string
+		wantState map[string]interface{}
+		wantVars  map[string]interface{}
+	}{
+		{`hepi.log("nothing to change")`, map[string]interface{}{
+			"list":  map[string]interface{}{"items": []interface{}{}, "count": 3},
+			"old":   "x",
+			"empty": nil,
+		}, map[string]interface{}{"n": 7, "none": nil}},
+		{`state.empty = "set"`, map[string]interface{}{
+			"list":  map[string]interface{}{"items": []interface{}{}, "count": 3},
+			"old":   "x",
+			"empty": "set",
+		}, map[string]interface{}{"n": 7, "none": nil}},
+		{`
+state.list.count = 4
+state.old = nil
+state.token = "abc"
+vars.n = vars.n + 1
+`, map[string]interface{}{
+			"list":  map[string]interface{}{"items": []interface{}{}, "count": 4},
+			"empty": nil,
+			"token": "abc",
+		}, map[string]interface{}{"n": 8, "none": nil}},
+	}
+	for _, tt := range tests {
+		r := newTestRunner(t)
+		r.State["list"] = map[string]interface{}{"items": []interface{}{}, "count": 3}
+		r.State["old"] = "x"
+		r.State["empty"] = nil
+		r.Vars["n"] = 7
+		r.Vars["none"] = nil
+
+		if err := r.runScript("post", tt.script, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r.State, tt.wantState) {
+			t.Errorf("state = %#v, want %#v", r.State, tt.wantState)
+		}
+		if !reflect.DeepEqual(r.Vars, tt.wantVars) {
+			t.Errorf("vars = %#v, want %#v", r.Vars, tt.wantVars)
+		}
+	}
+}
+
+func TestPostScriptResponseJSON(t *testing.T) {
+	r := newTestRunner(t)
+	original := map[string]interface{}{"items": []interface{}{}}
+
+	resp := &scriptResponse{Status: 200, Headers: map[string]string{}, Body: []byte(`{"items":[]}`), JSON: original}
+	if err := r.runScript("post", `hepi.log(response.status)`, nil, resp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.JSON, original) {
+		t.Errorf("json = %#v, want it unchanged", resp.JSON)
+	}
+
+	if err := r.runScript("post", `response.json.total = 0`, nil, resp); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"items": []interface{}{}, "total": float64(0)}
+	if !reflect.DeepEqual(resp.JSON, want) {
+		t.Errorf("json = %#v, want %#v", resp.JSON, want)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Stream types.
+const (
+	streamSSE     = "sse"     // text/event-stream
+	streamNDJSON  = "ndjson"  // one JSON value per line
+	streamChunked = "chunked" // every chunk as it arrives
+)
+
+// errStreamTimeout cancels a streaming request whose response headers did not
+// arrive within the timeout.
+var errStreamTimeout = errors.New("stream timed out")
+
+// StreamConfig reads a response incrementally. It is written either as the
+// stream type or as a mapping with stop conditions:
+//
+//	stream: sse
+//	stream: {type: ndjson, max_events: 10, duration: 30s, until: {value: "{{event.data.done}}", equals: "true"}}
+type StreamConfig struct {
+	Type      string     `yaml:"type"`
+	MaxEvents int        `yaml:"max_events"`
+	Duration  string     `yaml:"duration"`
+	Until     *Assertion `yaml:"until"`
+}
+
+func (s *StreamConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s.Type = value.Value
+		return nil
+	}
+	type plain StreamConfig
+	return value.Decode((*plain)(s))
+}
+
+// streamEvent is a single event read from a stream.
+type streamEvent struct {
+	name    string
+	id      string
+	data    string
+	elapsed time.Duration
+}
+
+// toMap describes the event for the state. JSON data is decoded.
+func (e streamEvent) toMap(typ string) map[string]interface{} {
+	var data interface{} = e.data
+	if typ != streamChunked {
+		var parsed interface{}
+		if json.Unmarshal([]byte(e.data), &parsed) == nil {
+			data = parsed
+		}
+	}
+
+	event := map[string]interface{}{
+		"data":       data,
+		"elapsed_ms": e.elapsed.Milliseconds(),
+	}
+	if typ == streamSSE {
+		event["event"] = e.name
+		if e.id != "" {
+			event["id"] = e.id
+		}
+	}
+	return event
+}
+
+// readStream prints the events of a streamed response as they arrive and
+// returns them once the stream ends or a stop condition is met. Within the
+// `until` assertion, the current event is available as {{event}}.
+func (r *Runner) readStream(body io.Reader, c *StreamConfig) ([]interface{}, error) {
+	switch c.Type {
+	case streamSSE, streamNDJSON, streamChunked:
+	default:
+		return nil, fmt.Errorf("%sunknown stream type %q (expected sse, ndjson or chunked)%s", colorRed, c.Type, colorReset)
+	}
+
+	var deadline <-chan time.Time
+	if c.Duration != "" {
+		d, err := parseDuration(r.substitute(c.Duration))
+		if err != nil {
+			return nil, fmt.Errorf("%sstream duration: %v%s", colorRed, err, colorReset)
+		}
+		deadline = time.After(d)
+	}
+
+	// Events are parsed in the background, so a duration can end the stream
+	// while no data arrives. Closing the body stops the reader.
+	eventCh := make(chan streamEvent)
+	errCh := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		errCh <- parseStream(body, c.Type, time.Now(), func(e streamEvent) bool {
+			select {
+			case eventCh <- e:
+				return true
+			case <-done:
+				return false
+			}
+		})
+		close(eventCh)
+	}()
+
+	fmt.Printf("\n%sEvents:%s\n", colorBold, colorReset)
+	events := []interface{}{}
+	for {
+		select {
+		case <-deadline:
+			fmt.Printf("%sStopped after %s%s\n", colorYellow, c.Duration, colorReset)
+			return events, nil
+		case e, ok := <-eventCh:
+			if !ok {
+				if err := <-errCh; err != nil {
+					return events, fmt.Errorf("%sfailed to read stream: %w%s", colorRed, err, colorReset)
+				}
+				fmt.Printf("%sStream ended%s\n", colorYellow, colorReset)
+				return events, nil
+			}
+
+			event := e.toMap(c.Type)
+			events = append(events, event)
+			printStreamEvent(len(events), e, c.Type)
+
+			if c.MaxEvents > 0 && len(events) >= c.MaxEvents {
+				fmt.Printf("%sStopped after %d events%s\n", colorYellow, len(events), colorReset)
+				return events, nil
+			}
+			if c.Until != nil {
+				met, err := r.streamConditionMet(c.Until, event, e.data)
+				if err != nil {
+					return events, err
+				}
+				if met {
+					fmt.Printf("%sStopped: until %s%s\n", colorYellow, c.Until.label(), colorReset)
+					return events, nil
+				}
+			}
+		}
+	}
+}
+
+// streamConditionMet checks the `until` assertion against an event. Without
+// a value, the raw event data is checked.
+func (r *Runner) streamConditionMet(until *Assertion, event map[string]interface{}, data string) (bool, error) {
+	actual := data
+	if until.Value != "" {
+		actual = r.substituteWith(until.Value, "event", event)
+	}
+	message, err := until.check(actual)
+	if err != nil {
+		return false, fmt.Errorf("%sstream until: %v%s", colorRed, err, colorReset)
+	}
+	return message == "", nil
+}
+
+// parseStream reads body and calls emit for every event until the body ends
+// or emit returns false.
+func parseStream(body io.Reader, typ string, start time.Time, emit func(streamEvent) bool) error {
+	if typ == streamChunked {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 && !emit(streamEvent{data: string(buf[:n]), elapsed: time.Since(start)}) {
+				return nil
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	reader := bufio.NewReader(body)
+	var event streamEvent
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+		line = strings.TrimRight(line, "\r\n")
+
+		if typ == streamNDJSON {
+			if strings.TrimSpace(line) != "" && !emit(streamEvent{data: line, elapsed: time.Since(start)}) {
+				return nil
+			}
+			if eof {
+				return nil
+			}
+			continue
+		}
+
+		// Server-sent events: fields until a blank line dispatches the event.
+		// The end of the stream dispatches a pending event too, so the last
+		// event of a server that closes right after its data is not lost.
+		if line != "" && !strings.HasPrefix(line, ":") {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event.name = value
+			case "data":
+				data = append(data, value)
+			case "id":
+				event.id = value
+			}
+		}
+		if line == "" || eof {
+			if data != nil {
+				event.data = strings.Join(data, "\n")
+				event.elapsed = time.Since(start)
+				if event.name == "" {
+					event.name = "message"
+				}
+				if !emit(event) {
+					return nil
+				}
+			}
+			event, data = streamEvent{id: event.id}, nil
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+// printStreamEvent prints one event with its number and arrival time.
+func printStreamEvent(n int, e streamEvent, typ string) {
+	label := ""
+	if typ == streamSSE {
+		label = e.name + " "
+	}
+	fmt.Printf("  %s#%d%s %s+%v%s %s%s\n", colorCyan, n, colorReset, colorYellow, e.elapsed.Round(time.Millisecond), colorReset, label, strings.ReplaceAll(strings.TrimRight(e.data, "\n"), "\n", "\n    "))
+}
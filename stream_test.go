@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collectStream(t *testing.T, body, typ string) []streamEvent {
+	t.Helper()
+	var events []streamEvent
+	err := parseStream(strings.NewReader(body), typ, time.Now(), func(e streamEvent) bool {
+		e.elapsed = 0
+		events = append(events, e)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("parseStream: %v", err)
+	}
+	return events
+}
+
+func TestParseStream(t *testing.T) {
+	tests := []struct {
+		typ, body string
+		want      []streamEvent
+	}{
+		{streamSSE, ": comment\n" +
+			"data: first\n\n" +
+			"event: update\nid: 7\ndata: {\"n\": 1}\ndata: more\n\n" +
+			"data: last\n", []streamEvent{
+			{name: "message", data: "first"},
+			{name: "update", id: "7", data: "{\"n\": 1}\nmore"},
+			{name: "message", id: "7", data: "last"},
+		}},
+		{streamSSE, "data: a\n\nevent: end\ndata: [DONE]", []streamEvent{
+			{name: "message", data: "a"},
+			{name: "end", data: "[DONE]"},
+		}},
+		{streamSSE, "data: a\n\nevent: end\n", []streamEvent{
+			{name: "message", data: "a"},
+		}},
+		{streamNDJSON, "{\"a\":1}\n\n{\"a\":2}\r\n{\"a\":3}", []streamEvent{
+			{data: `{"a":1}`}, {data: `{"a":2}`}, {data: `{"a":3}`},
+		}},
+	}
+	for _, tt := range tests {
+		if got := collectStream(t, tt.body, tt.typ); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s events = %+v, want %+v", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestNDJSONEventDecodesData(t *testing.T) {
+	event := streamEvent{data: `{"a":1}`}.toMap(streamNDJSON)
+	if !reflect.DeepEqual(event["data"], map[string]interface{}{"a": float64(1)}) {
+		t.Errorf("decoded data = %#v", event["data"])
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	done, marker := "true", "[DONE]"
+	tests := []struct {
+		body   string
+		config StreamConfig
+		want   int
+	}{
+		{"{\"done\":false}\n{\"done\":true}\n{\"done\":false}\n", StreamConfig{
+			Type:  streamNDJSON,
+			Until: &Assertion{Value: "{{event.data.done}}", Equals: &done},
+		}, 2},
+		{"data: 1\n\ndata: [DONE]", StreamConfig{
+			Type:  streamSSE,
+			Until: &Assertion{Value: "{{event.data}}", Equals: &marker},
+		}, 2},
+		{"1\n2\n3\n", StreamConfig{Type: streamNDJSON, MaxEvents: 2}, 2},
+		{"1\n2\n3\n", StreamConfig{Type: streamNDJSON}, 3},
+	}
+	for _, tt := range tests {
+		r := newTestRunner(t)
+		r.Vars["event"] = "mine"
+		events, err := r.readStream(strings.NewReader(tt.body), &tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != tt.want {
+			t.Errorf("read %d events from %q, want %d", len(events), tt.body, tt.want)
+		}
+		if r.Vars["event"] != "mine" {
+			t.Errorf("vars event = %v, want it restored to mine", r.Vars["event"])
+		}
+	}
+}
+
+func TestReadStreamEmpty(t *testing.T) {
+	r := newTestRunner(t)
+	events, err := r.readStream(strings.NewReader(""), &StreamConfig{Type: streamSSE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := json.Marshal(events); string(data) != "[]" {
+		t.Errorf("empty stream stored as %s, want []", data)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"time"
+)
+
+// requestTiming collects the phases of a request through httptrace. When
+// redirects are followed, the phases are those of the final request, while
+// the total covers the whole chain.
+type requestTiming struct {
+	start        time.Time
+	hopStart     time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	done         time.Time
+	reused       bool
+	remoteAddr   string
+}
+
+// trace returns the hooks that fill in t.
+func (t *requestTiming) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			// Every redirect starts over with a new request
+			*t = requestTiming{start: t.start, hopStart: time.Now()}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+		ConnectStart: func(string, string) {
+			// Several addresses may be tried; the phase starts with the first
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone:       func(string, string, error) { t.connectDone = time.Now() },
+		TLSHandshakeStart: func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.tlsDone = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.reused = info.Reused
+			if info.Conn != nil {
+				t.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+}
+
+// between returns the time from a to b, or zero when a phase did not happen.
+func between(a, b time.Time) time.Duration {
+	if a.IsZero() || b.IsZero() {
+		return 0
+	}
+	return b.Sub(a)
+}
+
+// phases lists the measured phases in order. Time to first byte is measured
+// from the start of the final request, the others are the length of their
+// phase.
+func (t *requestTiming) phases() []struct {
+	key, label string
+	d          time.Duration
+} {
+	hopStart := t.hopStart
+	if hopStart.IsZero() {
+		hopStart = t.start
+	}
+	return []struct {
+		key, label string
+		d          time.Duration
+	}{
+		{"dns_ms", "DNS lookup", between(t.dnsStart, t.dnsDone)},
+		{"connect_ms", "TCP connect", between(t.connectStart, t.connectDone)},
+		{"tls_ms", "TLS handshake", between(t.tlsStart, t.tlsDone)},
+		{"ttfb_ms", "Time to first byte", between(hopStart, t.firstByte)},
+		{"transfer_ms", "Content transfer", between(t.firstByte, t.done)},
+		{"total_ms", "Total", between(t.start, t.done)},
+	}
+}
+
+// toMap describes the timing for the state, in milliseconds.
+func (t *requestTiming) toMap() map[string]interface{} {
+	res := map[string]interface{}{"reused": t.reused}
+	for _, p := range t.phases() {
+		res[p.key] = float64(p.d.Microseconds()) / 1000
+	}
+	return res
+}
+
+// print shows the phases of the request.
+func (t *requestTiming) print() {
+	fmt.Printf("\n%sTiming:%s\n", colorBold, colorReset)
+	for _, p := range t.phases() {
+		fmt.Printf("  %-20s %s%v%s\n", p.label, colorYellow, p.d.Round(10*time.Microsecond), colorReset)
+	}
+
+	conn := "new"
+	if t.reused {
+		conn = "reused"
+	}
+	if t.remoteAddr != "" {
+		conn += " (" + t.remoteAddr + ")"
+	}
+	fmt.Printf("  %-20s %s\n", "Connection", conn)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+)
+
+func TestTimingReportsFinalHop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+			http.Redirect(w, r, "/done", http.StatusFound)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	timing := &requestTiming{start: time.Now()}
+	req, _ := http.NewRequest("GET", srv.URL+"/slow", nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timing.trace()))
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	timing.done = time.Now()
+
+	m := timing.toMap()
+	if ttfb := m["ttfb_ms"].(float64); ttfb >= 200 {
+		t.Errorf("ttfb_ms = %v, want the final request only", ttfb)
+	}
+	if total := m["total_ms"].(float64); total < 200 {
+		t.Errorf("total_ms = %v, want the whole redirect chain", total)
+	}
+	if !timing.reused {
+		t.Error("final request did not report the reused connection")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// tlsInfo describes a TLS connection for the state, e.g.
+// {{name.response.tls.days_until_expiry}} for the server certificate.
+func tlsInfo(state *tls.ConnectionState) map[string]interface{} {
+	var certs []interface{}
+	for _, cert := range state.PeerCertificates {
+		certs = append(certs, map[string]interface{}{
+			"subject":           cert.Subject.String(),
+			"issuer":            cert.Issuer.String(),
+			"sans":              stringsToInterface(certNames(cert)),
+			"not_before":        cert.NotBefore.UTC().Format(time.RFC3339),
+			"not_after":         cert.NotAfter.UTC().Format(time.RFC3339),
+			"days_until_expiry": daysUntil(cert.NotAfter),
+		})
+	}
+
+	info := map[string]interface{}{
+		"version":      tls.VersionName(state.Version),
+		"cipher":       tls.CipherSuiteName(state.CipherSuite),
+		"alpn":         state.NegotiatedProtocol,
+		"server_name":  state.ServerName,
+		"certificates": certs,
+	}
+	if len(state.PeerCertificates) > 0 {
+		info["days_until_expiry"] = daysUntil(state.PeerCertificates[0].NotAfter)
+	}
+	return info
+}
+
+// printTLSInfo prints the negotiated parameters and the peer certificate chain.
+func printTLSInfo(state *tls.ConnectionState) {
+	alpn := state.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "-"
+	}
+	fmt.Printf("\n%sTLS:%s %s, %s, ALPN %s\n", colorBold, colorReset, tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), alpn)
+
+	for i, cert := range state.PeerCertificates {
+		days := daysUntil(cert.NotAfter)
+		expiryColor := colorGreen
+		if days < 0 {
+			expiryColor = colorRed
+		} else if days < 30 {
+			expiryColor = colorYellow
+		}
+
+		fmt.Printf("  %s%d%s %s\n", colorCyan, i, colorReset, cert.Subject)
+		fmt.Printf("    Issuer:  %s\n", cert.Issuer)
+		if names := certNames(cert); len(names) > 0 {
+			fmt.Printf("    SANs:    %s\n", strings.Join(names, ", "))
+		}
+		fmt.Printf("    Expires: %s%s (%d days)%s\n", expiryColor, cert.NotAfter.UTC().Format(time.DateOnly), days, colorReset)
+	}
+}
+
+// certNames lists the subject alternative names of a certificate.
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+// daysUntil returns the number of whole days until t, negative once t has passed.
+func daysUntil(t time.Time) int {
+	return int(math.Floor(time.Until(t).Hours() / 24))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTLSInfo(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	info := tlsInfo(resp.TLS)
+	if info["version"] != tls.VersionName(resp.TLS.Version) || info["cipher"] == "" {
+		t.Errorf("version = %v, cipher = %v", info["version"], info["cipher"])
+	}
+	certs := info["certificates"].([]interface{})
+	if len(certs) == 0 {
+		t.Fatal("no certificates recorded")
+	}
+	leaf := certs[0].(map[string]interface{})
+	sans := leaf["sans"].([]interface{})
+	found := false
+	for _, san := range sans {
+		found = found || san == "127.0.0.1"
+	}
+	if !found {
+		t.Errorf("sans = %v, want the server address", sans)
+	}
+	if days, ok := info["days_until_expiry"].(int); !ok || days <= 0 || days != leaf["days_until_expiry"] {
+		t.Errorf("days_until_expiry = %v, leaf %v", info["days_until_expiry"], leaf["days_until_expiry"])
+	}
+	if _, err := time.Parse(time.RFC3339, leaf["not_after"].(string)); err != nil {
+		t.Errorf("not_after: %v", err)
+	}
+}
+
+func TestDaysUntil(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want int
+	}{
+		{36 * time.Hour, 1},
+		{49 * time.Hour, 2},
+		{time.Hour, 0},
+		{-time.Hour, -1},
+		{-25 * time.Hour, -2},
+	}
+	for _, tt := range tests {
+		if got := daysUntil(time.Now().Add(tt.in)); got != tt.want {
+			t.Errorf("daysUntil(now%+v) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// TLSConfig is the `tls:` block of an environment. String values may contain
+// placeholders, e.g. `password: "{{P12_PASSWORD}}"`.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	Password           string `yaml:"password"`
+	ServerName         string `yaml:"server_name"`
+	MinVersion         string `yaml:"min_version"`
+	InsecureSkipVerify *bool  `yaml:"insecure_skip_verify"`
+
+	// dir is the directory relative file paths are resolved against, that of
+	// the file defining the environment.
+	dir string
+}
+
+// merge layers the settings of override that are set over c.
+func (c TLSConfig) merge(override TLSConfig) TLSConfig {
+	for _, f := range []struct{ dst, src *string }{
+		{&c.CAFile, &override.CAFile},
+		{&c.CertFile, &override.CertFile},
+		{&c.KeyFile, &override.KeyFile},
+		{&c.Password, &override.Password},
+		{&c.ServerName, &override.ServerName},
+		{&c.MinVersion, &override.MinVersion},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	if override.InsecureSkipVerify != nil {
+		c.InsecureSkipVerify = override.InsecureSkipVerify
+	}
+	return c
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TransportConfig holds the connection settings that can be given per
+// environment and per request. Request settings override the environment's.
+type TransportConfig struct {
+	Proxy        *ProxyConfig `yaml:"proxy"`
+	UnixSocket   string       `yaml:"unix_socket"`
+	Resolve      []string     `yaml:"resolve"`
+	ConnectTo    []string     `yaml:"connect_to"`
+	IPVersion    string       `yaml:"ip_version"`
+	LocalAddress string       `yaml:"local_address"`
+	HTTPVersion  string       `yaml:"http_version"`
+}
+
+// merge layers the settings of override that are set over c. Resolve and
+// connect_to entries of override are added in front, so they match first.
+func (c TransportConfig) merge(override TransportConfig) TransportConfig {
+	if override.Proxy != nil {
+		c.Proxy = override.Proxy
+	}
+	if override.UnixSocket != "" {
+		c.UnixSocket = override.UnixSocket
+	}
+	if override.Resolve != nil {
+		c.Resolve = append(append([]string{}, override.Resolve...), c.Resolve...)
+	}
+	if override.ConnectTo != nil {
+		c.ConnectTo = append(append([]string{}, override.ConnectTo...), c.ConnectTo...)
+	}
+	if override.IPVersion != "" {
+		c.IPVersion = override.IPVersion
+	}
+	if override.LocalAddress != "" {
+		c.LocalAddress = override.LocalAddress
+	}
+	if override.HTTPVersion != "" {
+		c.HTTPVersion = override.HTTPVersion
+	}
+	return c
+}
+
+// ProxyConfig routes requests through an HTTP, HTTPS or SOCKS5 proxy. It is
+// written either as a URL or as a mapping:
+//
+//	proxy: socks5://127.0.0.1:1080
+//	proxy: {url: http://proxy:3128, username: bob, password: "{{PROXY_PASSWORD}}", no_proxy: ".internal"}
+type ProxyConfig struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	NoProxy  string `yaml:"no_proxy"`
+}
+
+func (p *ProxyConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		p.URL = value.Value
+		return nil
+	}
+	type plain ProxyConfig
+	return value.Decode((*plain)(p))
+}
+
+// clientFor returns an HTTP client using the transport for the request's
+// connection settings layered over the environment's.
+func (r *Runner) clientFor(req Request) (*http.Client, error) {
+	transport, err := r.transportFor(r.Transport.merge(req.TransportConfig))
+	if err != nil {
+		return nil, err
+	}
+	client := *r.HTTPClient
+	client.Transport = transport
+	return &client, nil
+}
+
+// transportFor builds the transport for the given settings. Transports are
+// cached by their resolved settings so connections are reused across requests.
+func (r *Runner) transportFor(c TransportConfig) (*http.Transport, error) {
+	if c.Proxy != nil {
+		c.Proxy = &ProxyConfig{
+			URL:      r.substitute(c.Proxy.URL),
+			Username: r.substitute(c.Proxy.Username),
+			Password: r.substitute(c.Proxy.Password),
+			NoProxy:  r.substitute(c.Proxy.NoProxy),
+		}
+	}
+	c.UnixSocket = r.substitute(c.UnixSocket)
+	c.Resolve = r.substituteStrings(c.Resolve)
+	c.ConnectTo = r.substituteStrings(c.ConnectTo)
+	c.IPVersion = r.substitute(c.IPVersion)
+	c.LocalAddress = r.substitute(c.LocalAddress)
+	c.HTTPVersion = r.substitute(c.HTTPVersion)
+
+	key, _ := json.Marshal(c)
+	if transport, ok := r.transports[string(key)]; ok {
+		return transport, nil
+	}
+
+	if r.tlsConfig == nil {
+		tlsConfig, err := r.buildTLSConfig(r.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("%stls: %v%s", colorRed, err, colorReset)
+		}
+		r.tlsConfig = tlsConfig
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = r.tlsConfig.Clone()
+
+	protocols, err := httpProtocols(c.HTTPVersion)
+	if err != nil {
+		return nil, fmt.Errorf("%s%v%s", colorRed, err, colorReset)
+	}
+	transport.Protocols = protocols
+
+	if c.Proxy != nil && c.Proxy.URL != "" {
+		proxy, err := proxyFunc(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%sproxy: %v%s", colorRed, err, colorReset)
+		}
+		transport.Proxy = proxy
+	}
+
+	dial, err := newDialer(c)
+	if err != nil {
+		return nil, fmt.Errorf("%s%v%s", colorRed, err, colorReset)
+	}
+	if dial != nil {
+		transport.DialContext = dial
+	}
+
+	if c.UnixSocket != "" {
+		// The URL only provides the Host header; every connection goes to the socket
+		socket := c.UnixSocket
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	if r.transports == nil {
+		r.transports = make(map[string]*http.Transport)
+	}
+	r.transports[string(key)] = transport
+	return transport, nil
+}
+
+// httpProtocols returns the protocols a transport may use for http_version:
+//
+//	1.1   HTTP/1.1 only
+//	2     HTTP/2 only, negotiated over TLS and with prior knowledge otherwise
+//	h2c   cleartext HTTP/2 with prior knowledge, for http:// URLs
+//	auto  HTTP/2 where the server supports it, HTTP/1.1 otherwise
+func httpProtocols(version string) (*http.Protocols, error) {
+	protocols := new(http.Protocols)
+	switch version {
+	case "", "auto":
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case "1.1", "1":
+		protocols.SetHTTP1(true)
+	case "2", "2.0":
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	case "h2c":
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unknown http_version %q (expected 1.1, 2, h2c or auto)", version)
+	}
+	return protocols, nil
+}
+
+// substituteStrings substitutes every element of a list.
+func (r *Runner) substituteStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	res := make([]string, len(list))
+	for i, s := range list {
+		res[i] = r.substitute(s)
+	}
+	return res
+}
+
+// newDialer returns a dial function that applies the connect_to and resolve
+// overrides, the IP version and the local address, or nil if none are set.
+// Only the TCP connection is redirected; the Host header and TLS server name
+// still come from the URL.
+//
+//	resolve:    api.example.com:443:10.0.0.5       (curl --resolve)
+//	connect_to: api.example.com:443:canary.internal:8443  (curl --connect-to)
+func newDialer(c TransportConfig) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	if len(c.Resolve) == 0 && len(c.ConnectTo) == 0 && c.IPVersion == "" && c.LocalAddress == "" {
+		return nil, nil
+	}
+
+	type override struct{ host, port, toHost, toPort string }
+	var resolves, connects []override
+	for _, entry := range c.Resolve {
+		parts := splitAddressList(entry)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("resolve: invalid entry %q (expected host:port:address)", entry)
+		}
+		resolves = append(resolves, override{parts[0], parts[1], parts[2], ""})
+	}
+	for _, entry := range c.ConnectTo {
+		parts := splitAddressList(entry)
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("connect_to: invalid entry %q (expected host:port:connect_host:connect_port)", entry)
+		}
+		connects = append(connects, override{parts[0], parts[1], parts[2], parts[3]})
+	}
+
+	var suffix string
+	switch c.IPVersion {
+	case "", "auto":
+	case "4", "6":
+		suffix = c.IPVersion
+	default:
+		return nil, fmt.Errorf("unknown ip_version %q (expected 4 or 6)", c.IPVersion)
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if c.LocalAddress != "" {
+		ip := net.ParseIP(c.LocalAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local_address %q (expected an IP address)", c.LocalAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	matches := func(o override, host, port string) bool {
+		return (o.host == "" || strings.EqualFold(o.host, host)) && (o.port == "" || o.port == "*" || o.port == port)
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range connects {
+			if matches(o, host, port) {
+				if o.toHost != "" {
+					host = o.toHost
+				}
+				if o.toPort != "" {
+					port = o.toPort
+				}
+				break
+			}
+		}
+
+		targets := []string{host}
+		for _, o := range resolves {
+			if matches(o, host, port) {
+				targets = strings.Split(o.toHost, ",")
+				break
+			}
+		}
+
+		// Try each address in turn, like curl does for several --resolve addresses
+		for _, target := range targets {
+			target = strings.Trim(strings.TrimSpace(target), "[]")
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network+suffix, net.JoinHostPort(target, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}, nil
+}
+
+// splitAddressList splits a colon-separated list of hosts and ports, leaving
+// bracketed IPv6 addresses such as [::1] intact.
+func splitAddressList(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, strings.Trim(s[start:i], "[]"))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.Trim(s[start:], "[]"))
+}
+
+// proxyFunc returns the proxy selection for a transport. Hosts matching the
+// NO_PROXY style `no_proxy` list are connected to directly.
+func proxyFunc(c *ProxyConfig) (func(*http.Request) (*url.URL, error), error) {
+	raw := c.URL
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q (expected http, https or socks5)", proxyURL.Scheme)
+	}
+	if c.Username != "" {
+		proxyURL.User = url.UserPassword(c.Username, c.Password)
+	}
+
+	// Without a no_proxy setting, the NO_PROXY environment variable applies
+	exclude := c.NoProxy
+	if exclude == "" {
+		exclude = os.Getenv("NO_PROXY")
+	}
+	if exclude == "" {
+		exclude = os.Getenv("no_proxy")
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxy(exclude, req.URL) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// noProxy reports whether u matches a comma-separated NO_PROXY list. Entries
+// are `*`, host names (matching subdomains too, with or without a leading
+// dot), IP addresses and CIDR ranges, each optionally with a port.
+func noProxy(list string, u *url.URL) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(strings.ToLower(list), ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildTLSConfig turns the TLS settings into a tls.Config, loading the CA
+// bundle and client certificate they refer to.
+func (r *Runner) buildTLSConfig(c TLSConfig) (*tls.Config, error) {
+	config := &tls.Config{ServerName: r.substitute(c.ServerName)}
+
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[strings.TrimPrefix(r.substitute(c.MinVersion), "TLS")]
+		if !ok {
+			return nil, fmt.Errorf("unknown min_version %q (expected 1.0, 1.1, 1.2 or 1.3)", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if c.InsecureSkipVerify != nil && *c.InsecureSkipVerify {
+		fmt.Fprintf(os.Stderr, "%sWarning: TLS certificate verification is disabled%s\n", colorYellow, colorReset)
+		config.InsecureSkipVerify = true
+	}
+
+	if c.CAFile != "" {
+		path := c.path(r.substitute(c.CAFile))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", path)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" {
+		cert, err := loadClientCert(c.path(r.substitute(c.CertFile)), c.path(r.substitute(c.KeyFile)), r.substitute(c.Password))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if c.KeyFile != "" {
+		return nil, fmt.Errorf("key_file requires cert_file")
+	}
+
+	return config, nil
+}
+
+// path resolves a relative file path against the config directory.
+func (c TLSConfig) path(p string) string {
+	if p == "" || filepath.IsAbs(p) || c.dir == "" {
+		return p
+	}
+	return filepath.Join(c.dir, p)
+}
+
+// loadClientCert loads a client certificate from PEM files, or from a
+// PKCS#12 bundle (.p12 or .pfx) protected by password.
+func loadClientCert(certFile, keyFile, password string) (tls.Certificate, error) {
+	switch strings.ToLower(filepath.Ext(certFile)) {
+	case ".p12", ".pfx":
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %v", certFile, err)
+		}
+		cert := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+		for _, ca := range chain {
+			cert.Certificate = append(cert.Certificate, ca.Raw)
+		}
+		return cert, nil
+	}
+
+	if keyFile == "" {
+		return tls.Certificate{}, fmt.Errorf("cert_file %s needs a key_file", certFile)
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestNoProxy(t *testing.T) {
+	list := "localhost, .internal, 10.0.0.0/8, api.test:8443"
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://localhost/", true},
+		{"http://svc.internal/", true},
+		{"http://internal/", true},
+		{"http://10.1.2.3/", true},
+		{"https://api.test:8443/", true},
+		{"https://api.test/", false},
+		{"http://example.com/", false},
+		{"http://11.0.0.1/", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := noProxy(list, u); got != tt.want {
+			t.Errorf("noProxy(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestProxyFallsBackToNoProxyEnv(t *testing.T) {
+	t.Setenv("NO_PROXY", "skip.test")
+	proxy, err := proxyFunc(&ProxyConfig{URL: "http://proxy:3128"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, direct := range map[string]bool{"skip.test": true, "other.test": false} {
+		req, _ := http.NewRequest("GET", "http://"+host+"/", nil)
+		u, _ := proxy(req)
+		if (u == nil) != direct {
+			t.Errorf("proxy for %s = %v, want direct %v", host, u, direct)
+		}
+	}
+
+	// A no_proxy list replaces the environment variable
+	proxy, _ = proxyFunc(&ProxyConfig{URL: "http://proxy:3128", NoProxy: "other.test"})
+	req, _ := http.NewRequest("GET", "http://skip.test/", nil)
+	if u, _ := proxy(req); u == nil {
+		t.Error("NO_PROXY applied although no_proxy is set")
+	}
+}
+
+func TestTLSConfigPath(t *testing.T) {
+	c := TLSConfig{dir: "/etc/hepi"}
+	abs := filepath.Join(t.TempDir(), "ca.pem")
+	for in, want := range map[string]string{
+		"":             "",
+		"certs/ca.pem": filepath.Join("/etc/hepi", "certs/ca.pem"),
+		abs:            abs,
+	} {
+		if got := c.path(in); got != want {
+			t.Errorf("path(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := (TLSConfig{}).path("ca.pem"); got != "ca.pem" {
+		t.Errorf("path without a dir = %q, want it unchanged", got)
+	}
+}
+
+func TestHTTPProtocols(t *testing.T) {
+	tests := []struct {
+		version string
+		wantErr bool
+	}{
+		{"", false},
+		{"auto", false},
+		{"1.1", false},
+		{"2", false},
+		{"h2c", false},
+		{"3", true},
+	}
+	for _, tt := range tests {
+		if _, err := httpProtocols(tt.version); (err != nil) != tt.wantErr {
+			t.Errorf("httpProtocols(%q) error = %v, want error %v", tt.version, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProxyConfigYAML(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ProxyConfig
+	}{
+		{"socks5://127.0.0.1:1080", ProxyConfig{URL: "socks5://127.0.0.1:1080"}},
+		{"{url: proxy:3128, username: bob, password: pw, no_proxy: .internal}", ProxyConfig{URL: "proxy:3128", Username: "bob", Password: "pw", NoProxy: ".internal"}},
+	}
+	for _, tt := range tests {
+		var got ProxyConfig
+		if err := yaml.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("proxy %s = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+	if _, err := proxyFunc(&ProxyConfig{URL: "ftp://proxy"}); err == nil {
+		t.Error("an ftp proxy was accepted")
+	}
+}
+
+func TestRequestThroughProxy(t *testing.T) {
+	var seenURL, seenAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenURL, seenAuth = r.URL.String(), r.Header.Get("Proxy-Authorization")
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	r := newTestRunner(t)
+	r.Environment["proxy_password"] = "secret"
+	transport, err := r.transportFor(TransportConfig{Proxy: &ProxyConfig{
+		URL:      strings.TrimPrefix(proxy.URL, "http://"),
+		Username: "bob",
+		Password: "{{proxy_password}}",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("http://api.test/users?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "via proxy" || seenURL != "http://api.test/users?x=1" {
+		t.Errorf("proxy saw %q and answered %q", seenURL, body)
+	}
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:secret"))
+	if seenAuth != want {
+		t.Errorf("Proxy-Authorization = %q, want %q", seenAuth, want)
+	}
+}
+
+func TestRequestOverUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+r.URL.Path)
+	}))
+	srv.Listener = listener
+	srv.Start()
+	defer srv.Close()
+
+	r := newTestRunner(t)
+	transport, err := r.transportFor(TransportConfig{UnixSocket: socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("http://docker/v1/containers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "docker/v1/containers" {
+		t.Errorf("socket server answered %q, want the URL's host and path", body)
+	}
+}
+
+func TestSplitAddressList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"api.test:443:10.0.0.5", []string{"api.test", "443", "10.0.0.5"}},
+		{"api.test:443:[::1]", []string{"api.test", "443", "::1"}},
+		{"[2001:db8::1]:80:[::1]:8080", []string{"2001:db8::1", "80", "::1", "8080"}},
+		{"::canary:8443", []string{"", "", "canary", "8443"}},
+	}
+	for _, tt := range tests {
+		if got := splitAddressList(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitAddressList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTransportMergeOrder(t *testing.T) {
+	env := TransportConfig{Resolve: []string{"a:443:1.1.1.1"}, ConnectTo: []string{"a:443:b:443"}, IPVersion: "4"}
+	got := env.merge(TransportConfig{Resolve: []string{"a:443:2.2.2.2"}, LocalAddress: "127.0.0.1"})
+
+	if want := []string{"a:443:2.2.2.2", "a:443:1.1.1.1"}; !reflect.DeepEqual(got.Resolve, want) {
+		t.Errorf("resolve = %q, want the override first: %q", got.Resolve, want)
+	}
+	if !reflect.DeepEqual(got.ConnectTo, env.ConnectTo) || got.IPVersion != "4" || got.LocalAddress != "127.0.0.1" {
+		t.Errorf("merge = %+v", got)
+	}
+	if len(env.Resolve) != 1 {
+		t.Errorf("merge modified the base resolve list: %q", env.Resolve)
+	}
+}
+
+func TestDialerOverrides(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+
+	tests := []struct {
+		name string
+		c    TransportConfig
+		url  string
+	}{
+		{"resolve", TransportConfig{Resolve: []string{"api.test:" + port + ":127.0.0.1"}}, "http://api.test:" + port + "/"},
+		{"resolve several", TransportConfig{Resolve: []string{"api.test:*:127.0.0.2, 127.0.0.1"}}, "http://api.test:" + port + "/"},
+		{"connect_to", TransportConfig{ConnectTo: []string{"api.test:80:127.0.0.1:" + port}}, "http://api.test/"},
+		{"connect_to and resolve", TransportConfig{
+			ConnectTo: []string{"api.test:80:backend.test:" + port},
+			Resolve:   []string{"backend.test:" + port + ":[127.0.0.1]"},
+		}, "http://api.test/"},
+		{"ip_version", TransportConfig{IPVersion: "4", LocalAddress: "127.0.0.1", Resolve: []string{"api.test::127.0.0.1"}}, "http://api.test:" + port + "/"},
+	}
+	for _, tt := range tests {
+		r := newTestRunner(t)
+		transport, err := r.transportFor(tt.c)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(tt.url)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		host, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := strings.TrimPrefix(strings.TrimSuffix(tt.url, "/"), "http://"); string(host) != want {
+			t.Errorf("%s: server saw Host %q, want %q", tt.name, host, want)
+		}
+	}
+}
+
+func TestDialerErrors(t *testing.T) {
+	tests := []struct {
+		c    TransportConfig
+		want string
+	}{
+		{TransportConfig{Resolve: []string{"api.test:443"}}, "resolve: invalid entry"},
+		{TransportConfig{Resolve: []string{":443:10.0.0.1"}}, "resolve: invalid entry"},
+		{TransportConfig{ConnectTo: []string{"api.test:443:canary"}}, "connect_to: invalid entry"},
+		{TransportConfig{IPVersion: "5"}, "unknown ip_version"},
+		{TransportConfig{LocalAddress: "eth0"}, "invalid local_address"},
+	}
+	for _, tt := range tests {
+		if _, err := newDialer(tt.c); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("newDialer(%+v) error = %v, want %q", tt.c, err, tt.want)
+		}
+	}
+	if dial, err := newDialer(TransportConfig{}); dial != nil || err != nil {
+		t.Errorf("newDialer without overrides = %v, %v, want nil", dial != nil, err)
+	}
+}
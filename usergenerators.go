@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxGeneratorDepth bounds how deeply user-defined generators may nest, which
+// also catches generators that refer to themselves.
+const maxGeneratorDepth = 10
+
+// GeneratorDef is a user-defined generator from the top-level `generators`
+// section. It takes one of three forms:
+//
+//	plans: [free, pro, enterprise]          # pick one of the values
+//	skus: {file: skus.txt, values: [X-1]}   # values from a file, one per line
+//	contact: "[[first_name]]@example.com"  # template composing other generators
+//	address_obj: {street: "[[word]] 1"}     # object template
+//
+// A mapping with only `file` and `values` keys is a value list; any other
+// mapping is an object template.
+type GeneratorDef struct {
+	Values   []string
+	File     string
+	Template string
+	Object   map[string]interface{}
+}
+
+func (g *GeneratorDef) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		g.Template = value.Value
+		return nil
+	case yaml.SequenceNode:
+		return value.Decode(&g.Values)
+	case yaml.MappingNode:
+		isList := true
+		for i := 0; i < len(value.Content); i += 2 {
+			if key := value.Content[i].Value; key != "file" && key != "values" {
+				isList = false
+			}
+		}
+		if isList {
+			var list struct {
+				File   string   `yaml:"file"`
+				Values []string `yaml:"values"`
+			}
+			if err := value.Decode(&list); err != nil {
+				return err
+			}
+			g.File, g.Values = list.File, list.Values
+			return nil
+		}
+		return value.Decode(&g.Object)
+	}
+	return fmt.Errorf("line %d: generator must be a list, a string or a mapping", value.Line)
+}
+
+// loadValues appends the lines of the generator's file to its values. Blank
+// lines and lines starting with # are skipped.
+func (g *GeneratorDef) loadValues(dir string) error {
+	if g.File == "" {
+		return nil
+	}
+	path := g.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		g.Values = append(g.Values, line)
+	}
+	return scanner.Err()
+}
+
+// registerGenerators adds the user-defined generators to the generators of
+// this run. Like plugin generators, they may not replace a built-in generator
+// or one of a plugin.
+func (r *Runner) registerGenerators() error {
+	r.objectGenerators = make(map[string]map[string]interface{})
+
+	for name, def := range r.Config.Generators {
+		name, def := name, def
+		if _, exists := r.generators[name]; exists {
+			return fmt.Errorf("%sgenerator %q already exists as a built-in or plugin generator%s", colorRed, name, colorReset)
+		}
+		switch {
+		case def.Object != nil:
+			r.objectGenerators[name] = def.Object
+			r.generators[name] = func(args []string) (string, error) {
+				obj, err := r.expandObject(name)
+				if err != nil {
+					return "", err
+				}
+				data, err := json.Marshal(obj)
+				return string(data), err
+			}
+		case def.Template != "":
+			r.generators[name] = func(args []string) (string, error) {
+				if r.generatorDepth >= maxGeneratorDepth {
+					return "", fmt.Errorf("generator %q nests too deeply", name)
+				}
+				r.generatorDepth++
+				defer func() { r.generatorDepth-- }()
+				return r.substitute(def.Template), nil
+			}
+		default:
+			r.generators[name] = func(args []string) (string, error) {
+				if len(def.Values) == 0 {
+					return "", fmt.Errorf("generator %q has no values", name)
+				}
+				return def.Values[rng.Intn(len(def.Values))], nil
+			}
+		}
+	}
+	return nil
+}
+
+// expandObject substitutes an object template into a JSON subtree.
+func (r *Runner) expandObject(name string) (map[string]interface{}, error) {
+	if r.generatorDepth >= maxGeneratorDepth {
+		return nil, fmt.Errorf("generator %q nests too deeply", name)
+	}
+	r.generatorDepth++
+	defer func() { r.generatorDepth-- }()
+	return r.substituteMap(r.objectGenerators[name]), nil
+}
+
+// substituteValue substitutes a single string value. A value consisting only
+// of an object generator tag, e.g. `address: "[[address_obj]]"`, expands into
+// the object itself rather than its JSON text.
+func (r *Runner) substituteValue(s string) interface{} {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "[[") && strings.HasSuffix(trimmed, "]]") && strings.Count(trimmed, "[[") == 1 {
+		tag, bind := splitBinding(strings.TrimSpace(trimmed[2 : len(trimmed)-2]))
+		name, _ := parseGeneratorTag(tag)
+		if _, ok := r.objectGenerators[name]; ok {
+			obj, err := r.expandObject(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sWarning: %s: %v%s\n", colorYellow, s, err, colorReset)
+				return s
+			}
+			if bind != "" {
+				r.Vars[bind] = obj
+				if r.bound != nil {
+					r.bound[bind] = obj
+				}
+			}
+			return obj
+		}
+	}
+	return r.substitute(s)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRegisterGeneratorsRejectsConflicts(t *testing.T) {
+	for _, name := range []string{"email", "account"} {
+		r := newTestRunner(t)
+		if err := r.registerPlugins([]*Plugin{{Name: "p", Generators: []string{"account"}}}); err != nil {
+			t.Fatal(err)
+		}
+		r.Config.Generators = map[string]GeneratorDef{name: {Values: []string{"x"}}}
+		if err := r.registerGenerators(); err == nil {
+			t.Errorf("a user generator replaced the existing %s generator", name)
+		}
+	}
+}
+
+func TestGeneratorDefForms(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"skus.txt": "# SKUs\nSKU-1\n\n  SKU-2  \n"})
+
+	var defs map[string]GeneratorDef
+	err := yaml.Unmarshal([]byte(`
+plan: [free, pro]
+sku: {file: skus.txt, values: [SKU-TEST]}
+contact: "[[first_name]]@example.com"
+address_obj: {street: "[[word]] 1", file: x}
+`), &defs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, def := range defs {
+		if err := def.loadValues(dir); err != nil {
+			t.Fatal(err)
+		}
+		defs[name] = def
+	}
+
+	want := map[string]GeneratorDef{
+		"plan":        {Values: []string{"free", "pro"}},
+		"sku":         {File: "skus.txt", Values: []string{"SKU-TEST", "SKU-1", "SKU-2"}},
+		"contact":     {Template: "[[first_name]]@example.com"},
+		"address_obj": {Object: map[string]interface{}{"street": "[[word]] 1", "file": "x"}},
+	}
+	if !reflect.DeepEqual(defs, want) {
+		t.Errorf("generators = %+v, want %+v", defs, want)
+	}
+}
+
+func TestUserGenerators(t *testing.T) {
+	r := newTestRunner(t)
+	r.Config.Generators = map[string]GeneratorDef{
+		"plan":        {Values: []string{"pro"}},
+		"contact":     {Template: "[[plan]]@example.com"},
+		"address_obj": {Object: map[string]interface{}{"plan": "[[plan]]", "n": 1}},
+		"loop":        {Template: "[[loop]]"},
+	}
+	if err := r.registerGenerators(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"[[plan]]", "pro"},
+		{"[[contact]]", "pro@example.com"},
+		{"[[address_obj]]", map[string]interface{}{"plan": "pro", "n": 1}},
+		{"x [[address_obj]]", `x {"n":1,"plan":"pro"}`},
+	}
+	for _, tt := range tests {
+		if got := r.substituteValue(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("substituteValue(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+
+	// Nesting stops at maxGeneratorDepth, leaving the innermost tag in place
+	if got, err := r.generators["loop"](nil); err != nil || got != "[[loop]]" {
+		t.Errorf("self-referencing generator = %q, %v", got, err)
+	}
+}
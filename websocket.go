@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketStep is one step of a `websocket` script: either a message to
+// send or a message to wait for.
+type WebSocketStep struct {
+	Send   interface{}      `yaml:"send"`
+	Expect *WebSocketExpect `yaml:"expect"`
+}
+
+// WebSocketExpect waits for a message matching an assertion. The assertion
+// checks the value at `path` of a JSON message, its substituted `value`, in
+// which the message is available as {{message}}, or else the raw message.
+type WebSocketExpect struct {
+	Assertion `yaml:",inline"`
+	Path      string `yaml:"path"`
+	Timeout   string `yaml:"timeout"`
+}
+
+// label describes the expectation in the output, e.g. `type equals "joined"`.
+func (e *WebSocketExpect) label() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	target := "message"
+	if e.Path != "" {
+		target = e.Path
+	} else if e.Value != "" {
+		target = e.Value
+	}
+	switch {
+	case e.Equals != nil:
+		return fmt.Sprintf("%s equals %q", target, *e.Equals)
+	case e.NotEquals != nil:
+		return fmt.Sprintf("%s not equals %q", target, *e.NotEquals)
+	case e.Contains != nil:
+		return fmt.Sprintf("%s contains %q", target, *e.Contains)
+	case e.Matches != "":
+		return fmt.Sprintf("%s matches %q", target, e.Matches)
+	}
+	return target
+}
+
+// Headers set by the WebSocket handshake itself, which may not be repeated.
+var websocketHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+}
+
+// executeWebSocket connects to a ws:// or wss:// URL with the request's
+// headers and connection settings, runs its script and closes the connection.
+// The received messages are stored in the state as an array.
+func (r *Runner) executeWebSocket(name string, req Request, outgoing *scriptRequest) error {
+	fmt.Printf("%sWS%s %s\n", colorCyan, colorReset, outgoing.URL)
+
+	transport, err := r.transportFor(r.Transport.merge(req.TransportConfig))
+	if err != nil {
+		return err
+	}
+	tlsConfig := transport.TLSClientConfig.Clone()
+	// WebSockets are upgraded from HTTP/1.1
+	tlsConfig.NextProtos = nil
+	dialer := &websocket.Dialer{
+		Proxy:            transport.Proxy,
+		NetDialContext:   transport.DialContext,
+		TLSClientConfig:  tlsConfig,
+		HandshakeTimeout: r.HTTPClient.Timeout,
+	}
+
+	header := make(http.Header)
+	for k, v := range outgoing.Headers {
+		if !websocketHeaders[http.CanonicalHeaderKey(k)] {
+			header.Set(k, v)
+		}
+	}
+
+	timing := &requestTiming{}
+	ctx := httptrace.WithClientTrace(context.Background(), timing.trace())
+	startTime := time.Now()
+	timing.start = startTime
+	conn, resp, err := dialer.DialContext(ctx, outgoing.URL, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("%swebsocket handshake failed: %s%s", colorRed, resp.Status, colorReset)
+		}
+		return fmt.Errorf("%swebsocket connection failed: %w%s", colorRed, err, colorReset)
+	}
+	defer conn.Close()
+	duration := time.Since(startTime)
+	timing.firstByte = time.Now()
+
+	fmt.Printf("Status: %s%s%s (took %s%v%s)\n", colorGreen, resp.Status, colorReset, colorYellow, duration.Round(time.Millisecond), colorReset)
+	fmt.Printf("\n%sMessages:%s\n", colorBold, colorReset)
+
+	messages, scriptErr := r.runWebSocketScript(conn, req.WebSocket, startTime)
+
+	// Close cleanly and give the server a moment to answer the close frame
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			break
+		}
+	}
+	timing.done = time.Now()
+
+	if messages == nil {
+		messages = []interface{}{}
+	}
+	r.State[name] = messages
+	r.recordRequest(name, outgoing, resp, duration, timing, nil)
+	r.saveState()
+	if scriptErr != nil {
+		return scriptErr
+	}
+
+	if r.ShowTiming {
+		timing.print()
+	}
+
+	data, _ := json.Marshal(messages)
+	received := &scriptResponse{
+		Status:  resp.StatusCode,
+		Headers: make(map[string]string),
+		Body:    data,
+		JSON:    messages,
+	}
+	for k, v := range resp.Header {
+		received.Headers[k] = strings.Join(v, ", ")
+	}
+	if req.Post != "" {
+		if err := r.runScript("post", req.Post, nil, received); err != nil {
+			return err
+		}
+	}
+	return r.runAssertions(req.Assert, received)
+}
+
+// runWebSocketScript runs the send and expect steps in order and returns the
+// messages received on the way.
+func (r *Runner) runWebSocketScript(conn *websocket.Conn, steps []WebSocketStep, start time.Time) ([]interface{}, error) {
+	var messages []interface{}
+	for i, step := range steps {
+		switch {
+		case step.Send != nil:
+			var data []byte
+			switch v := step.Send.(type) {
+			case string:
+				data = []byte(r.substitute(v))
+			case map[string]interface{}:
+				data, _ = json.Marshal(r.substituteMap(v))
+			default:
+				data, _ = json.Marshal(r.substituteSlice([]interface{}{v})[0])
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return messages, fmt.Errorf("%swebsocket step %d: send failed: %w%s", colorRed, i+1, err, colorReset)
+			}
+			fmt.Printf("  %s>%s %s\n", colorGreen, colorReset, data)
+
+		case step.Expect != nil:
+			timeout := r.HTTPClient.Timeout
+			if step.Expect.Timeout != "" {
+				d, err := parseDuration(r.substitute(step.Expect.Timeout))
+				if err != nil {
+					return messages, fmt.Errorf("%swebsocket step %d: %v%s", colorRed, i+1, err, colorReset)
+				}
+				timeout = d
+			}
+			var deadline time.Time
+			if timeout > 0 {
+				deadline = time.Now().Add(timeout)
+			}
+			_ = conn.SetReadDeadline(deadline)
+
+			// Messages that do not match are kept and skipped
+			for {
+				kind, data, err := conn.ReadMessage()
+				if err != nil {
+					if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+						return messages, fmt.Errorf("%swebsocket step %d: no message matching %s within %v%s", colorRed, i+1, step.Expect.label(), timeout, colorReset)
+					}
+					return messages, fmt.Errorf("%swebsocket step %d: %w%s", colorRed, i+1, err, colorReset)
+				}
+
+				message := websocketMessage(kind, data, time.Since(start))
+				messages = append(messages, message)
+				fmt.Printf("  %s<%s %s\n", colorCyan, colorReset, data)
+
+				ok, err := r.websocketMatch(step.Expect, message, string(data))
+				if err != nil {
+					return messages, fmt.Errorf("%swebsocket step %d: %v%s", colorRed, i+1, err, colorReset)
+				}
+				if ok {
+					fmt.Printf("  %sPASS%s %s\n", colorGreen, colorReset, step.Expect.label())
+					break
+				}
+			}
+
+		default:
+			return messages, fmt.Errorf("%swebsocket step %d: expected send or expect%s", colorRed, i+1, colorReset)
+		}
+	}
+	return messages, nil
+}
+
+// websocketMessage describes a received message for the state. JSON text is
+// decoded.
+func websocketMessage(kind int, data []byte, elapsed time.Duration) map[string]interface{} {
+	message := map[string]interface{}{
+		"type":       "text",
+		"elapsed_ms": elapsed.Milliseconds(),
+	}
+	if kind == websocket.BinaryMessage {
+		message["type"] = "binary"
+	}
+	var parsed interface{}
+	if json.Unmarshal(data, &parsed) == nil {
+		message["data"] = parsed
+	} else {
+		message["data"] = string(data)
+	}
+	return message
+}
+
+// websocketMatch checks a received message against an expect step.
+func (r *Runner) websocketMatch(expect *WebSocketExpect, message map[string]interface{}, raw string) (bool, error) {
+	actual := raw
+	switch {
+	case expect.Path != "":
+		actual = getValueFromMap(message["data"], strings.Split(expect.Path, "."))
+	case expect.Value != "":
+		actual = r.substituteWith(expect.Value, "message", message)
+	}
+	failure, err := expect.check(actual)
+	return failure == "", err
+}
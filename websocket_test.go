@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
+)
+
+// newEchoServer starts a WebSocket server that sends every message back.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(kind, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// runWebSocket runs the websocket script given as YAML against url.
+func runWebSocket(t *testing.T, r *Runner, url, script string) error {
+	t.Helper()
+	var req Request
+	if err := yaml.Unmarshal([]byte(script), &req); err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+	outgoing := &scriptRequest{Method: "GET", URL: url, Headers: map[string]string{}}
+	return r.executeWebSocket("chat", req, outgoing)
+}
+
+func TestWebSocketSendExpect(t *testing.T) {
+	url := newEchoServer(t)
+	r := newTestRunner(t)
+	r.Environment["room"] = "lobby"
+
+	err := runWebSocket(t, r, url, `
+websocket:
+  - send: {type: join, room: "{{room}}"}
+  - expect: {path: type, equals: join}
+  - send: "hello {{room}}"
+  - expect: {contains: hello}
+`)
+	if err != nil {
+		t.Fatalf("executeWebSocket: %v", err)
+	}
+
+	messages, ok := r.State["chat"].([]interface{})
+	if !ok || len(messages) != 2 {
+		t.Fatalf("stored messages = %#v, want 2 messages", r.State["chat"])
+	}
+	first := messages[0].(map[string]interface{})
+	if first["type"] != "text" {
+		t.Errorf("first message type = %v, want text", first["type"])
+	}
+	data, ok := first["data"].(map[string]interface{})
+	if !ok || data["type"] != "join" {
+		t.Errorf("first message data = %#v, want decoded JSON", first["data"])
+	}
+	if _, ok := first["elapsed_ms"]; !ok {
+		t.Error("first message has no elapsed_ms")
+	}
+	if got := messages[1].(map[string]interface{})["data"]; got != "hello lobby" {
+		t.Errorf("second message data = %v, want hello lobby", got)
+	}
+}
+
+func TestWebSocketExpectSkipsOtherMessages(t *testing.T) {
+	url := newEchoServer(t)
+	r := newTestRunner(t)
+
+	err := runWebSocket(t, r, url, `
+websocket:
+  - send: "first"
+  - send: "second"
+  - expect: {equals: second}
+`)
+	if err != nil {
+		t.Fatalf("executeWebSocket: %v", err)
+	}
+	if messages := r.State["chat"].([]interface{}); len(messages) != 2 {
+		t.Errorf("stored %d messages, want 2", len(messages))
+	}
+}
+
+func TestWebSocketExpectTimeout(t *testing.T) {
+	url := newEchoServer(t)
+	r := newTestRunner(t)
+
+	start := time.Now()
+	err := runWebSocket(t, r, url, `
+websocket:
+  - send: "ping"
+  - expect: {equals: pong, timeout: 200ms}
+`)
+	if err == nil || !strings.Contains(err.Error(), "no message matching") {
+		t.Fatalf("err = %v, want an expect timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expect took %v, want it to stop after its timeout", elapsed)
+	}
+
+	// Messages received before the timeout are still stored
+	messages, ok := r.State["chat"].([]interface{})
+	if !ok || len(messages) != 1 {
+		t.Fatalf("stored messages = %#v, want the ping echo", r.State["chat"])
+	}
+	if got := messages[0].(map[string]interface{})["data"]; got != "ping" {
+		t.Errorf("stored message data = %v, want ping", got)
+	}
+}
+
+func TestWebSocketExpectValueKeepsVariable(t *testing.T) {
+	url := newEchoServer(t)
+	r := newTestRunner(t)
+	r.Vars["message"] = "mine"
+
+	err := runWebSocket(t, r, url, `
+websocket:
+  - send: {status: ok}
+  - expect: {value: "{{message.data.status}}", equals: ok}
+`)
+	if err != nil {
+		t.Fatalf("executeWebSocket: %v", err)
+	}
+	if r.Vars["message"] != "mine" {
+		t.Errorf("vars message = %v, want it restored to mine", r.Vars["message"])
+	}
+}